@@ -0,0 +1,341 @@
+// Package ast declares the types used to represent syntax trees of YAIL
+// programs.
+package ast
+
+import "fmt"
+
+// Pos is a position in the source code. Lines and columns start at 1, columns
+// are counted in bytes. The zero value means that the position is unknown.
+type Pos struct {
+	Line, Col int
+}
+
+func (p Pos) IsValid() bool {
+	return p.Line > 0
+}
+
+func (p Pos) String() string {
+	if !p.IsValid() {
+		return "-"
+	}
+	return fmt.Sprintf("%d:%d", p.Line, p.Col)
+}
+
+// Before reports whether p is located before q.
+func (p Pos) Before(q Pos) bool {
+	return p.Line < q.Line || (p.Line == q.Line && p.Col < q.Col)
+}
+
+// All nodes implement Node.
+type Node interface {
+	Pos() Pos // position of the first character of the node
+}
+
+// Statements implement Stmt.
+type Stmt interface {
+	Node
+	stmtNode()
+}
+
+// Expressions implement Expr.
+type Expr interface {
+	Node
+	exprNode()
+}
+
+// A Comment is a single // or /* */ comment.
+type Comment struct {
+	Slash Pos
+	Text  string // including the comment markers, without the trailing newline
+}
+
+func (c *Comment) Pos() Pos { return c.Slash }
+
+// A File is a parsed YAIL program.
+type File struct {
+	Stmts    []Stmt
+	Comments []*Comment // all comments of the file in the source order
+}
+
+func (f *File) Pos() Pos {
+	if len(f.Stmts) > 0 {
+		return f.Stmts[0].Pos()
+	}
+	return Pos{1, 1}
+}
+
+// Expressions.
+type (
+	// A Name is a (possibly indexed) variable name, e.g. ..name[i][j],
+	// possibly followed by fields of a record, e.g. name.pos.x. Every leading
+	// dot refers to the parent scope.
+	Name struct {
+		NamePos Pos // position of the first dot or the identifier
+		Dots    int
+		Name    string   // identifier without dots
+		Index   []Expr   // index expressions inside [ ]
+		Fields  []string // record fields after the variable
+	}
+
+	// A BasicLit is an int, float, string or bool literal.
+	BasicLit struct {
+		ValuePos Pos
+		Kind     LitKind
+		Value    string // literal as it appears in the source code
+	}
+
+	// A Builtin is one of the @ value producers, e.g. @int or @rnd.
+	Builtin struct {
+		At   Pos
+		Name string // including @
+	}
+
+	// A BuiltinCall is a call of a builtin function, e.g. type(x) or
+	// @open(name). The argument of defined is a *Name.
+	BuiltinCall struct {
+		NamePos Pos
+		Name    string
+		Lparen  Pos
+		Args    []Expr
+		Rparen  Pos
+	}
+
+	// A RecordLit is a record value, e.g. {name: "x", hp: 10}.
+	RecordLit struct {
+		Lbrace Pos
+		Fields []*Field
+		Rbrace Pos
+	}
+
+	// A FuncLit is a function definition, e.g. (a, b = 1, c...) { return a + b }.
+	FuncLit struct {
+		Lparen   Pos
+		Params   []*Name
+		Defaults []Expr // nil or default values of Params, nil for required ones
+		Ellipsis Pos    // of "..." if the last parameter collects other arguments
+		Body     *Block
+	}
+
+	UnaryExpr struct {
+		OpPos Pos
+		Op    string // -, ! or ~
+		X     Expr
+	}
+
+	BinaryExpr struct {
+		X     Expr
+		OpPos Pos
+		Op    string // e.g. + or &&
+		Y     Expr
+	}
+
+	// A CondExpr is a conditional expression "Cond ? X : Y".
+	CondExpr struct {
+		Cond     Expr
+		Question Pos
+		X        Expr
+		Colon    Pos
+		Y        Expr
+	}
+
+	ParenExpr struct {
+		Lparen Pos
+		X      Expr
+		Rparen Pos
+	}
+
+	CallExpr struct {
+		Fun    *Name
+		Lparen Pos
+		Args   []Expr
+		Rparen Pos
+	}
+)
+
+// LitKind is the kind of a BasicLit.
+type LitKind int
+
+const (
+	Int LitKind = iota
+	Float
+	String
+	Bool
+	Nil
+)
+
+func (x *Name) Pos() Pos        { return x.NamePos }
+func (x *BasicLit) Pos() Pos    { return x.ValuePos }
+func (x *Builtin) Pos() Pos     { return x.At }
+func (x *BuiltinCall) Pos() Pos { return x.NamePos }
+func (x *RecordLit) Pos() Pos   { return x.Lbrace }
+func (x *FuncLit) Pos() Pos     { return x.Lparen }
+func (x *UnaryExpr) Pos() Pos   { return x.OpPos }
+func (x *BinaryExpr) Pos() Pos  { return x.X.Pos() }
+func (x *CondExpr) Pos() Pos    { return x.Cond.Pos() }
+func (x *ParenExpr) Pos() Pos   { return x.Lparen }
+func (x *CallExpr) Pos() Pos    { return x.Fun.Pos() }
+
+func (*Name) exprNode()        {}
+func (*BasicLit) exprNode()    {}
+func (*Builtin) exprNode()     {}
+func (*BuiltinCall) exprNode() {}
+func (*RecordLit) exprNode()   {}
+func (*FuncLit) exprNode()     {}
+func (*UnaryExpr) exprNode()   {}
+func (*BinaryExpr) exprNode()  {}
+func (*CondExpr) exprNode()    {}
+func (*ParenExpr) exprNode()   {}
+func (*CallExpr) exprNode()    {}
+
+// A Field is a field of a record literal.
+type Field struct {
+	NamePos Pos
+	Name    string
+	Value   Expr
+}
+
+// Statements.
+type (
+	// A Block is a list of statements inside { }.
+	Block struct {
+		Lbrace Pos
+		Stmts  []Stmt
+		Rbrace Pos
+	}
+
+	// An AssignStmt stores Value to the variable Target.
+	AssignStmt struct {
+		Target *Name
+		Value  Expr
+	}
+
+	// A MultiAssignStmt is "a, b = x, y" or "a, b = f()" where f returns as
+	// many values as there are Targets.
+	MultiAssignStmt struct {
+		Targets []*Name
+		Values  []Expr // one call or one value for each target
+	}
+
+	// A CallStmt is a function call whose result is ignored.
+	CallStmt struct {
+		Call *CallExpr
+	}
+
+	// A BuiltinStmt is a call of a file builtin whose result is ignored,
+	// e.g. @close(f).
+	BuiltinStmt struct {
+		Call *BuiltinCall
+	}
+
+	// A PrintStmt is a call to @print or @println.
+	PrintStmt struct {
+		At      Pos
+		NewLine bool // @println
+		Lparen  Pos
+		Args    []Expr
+		Rparen  Pos
+	}
+
+	IfStmt struct {
+		If   Pos
+		Cond Expr
+		Body *Block
+		Else *Block // nil if there is no else branch
+	}
+
+	// A ForStmt is a loop "for Init; Cond; Post { }".
+	ForStmt struct {
+		For  Pos
+		Init *AssignStmt // may be nil
+		Cond Expr
+		Post *AssignStmt // may be nil
+		Body *Block
+	}
+
+	// A ForInStmt is a loop "for Var in X { }" over a range, a string,
+	// field names of a record or lines of a file.
+	ForInStmt struct {
+		For  Pos
+		Var  *Name
+		In   Pos
+		X    Expr
+		Body *Block
+	}
+
+	WhileStmt struct {
+		While Pos
+		Cond  Expr
+		Body  *Block
+	}
+
+	ReturnStmt struct {
+		Return Pos
+		Values []Expr // empty if nothing is returned
+	}
+
+	// A TryStmt is "try Body catch (Param) Catch finally Finally". Either
+	// the catch or the finally part may be missing.
+	TryStmt struct {
+		Try     Pos
+		Body    *Block
+		Param   *Name  // may be nil even if there is a catch part
+		Catch   *Block // nil if there is no catch part
+		Finally *Block // nil if there is no finally part
+	}
+
+	// A FuncDecl is a function declaration "func Name(params) { }". It is
+	// hoisted to the beginning of the enclosing block.
+	FuncDecl struct {
+		Func Pos
+		Name *Name
+		Fun  *FuncLit
+	}
+
+	// An ImportStmt is "import Path as Name". It declares Name, a record of
+	// the top-level variables of the module, like a FuncDecl does.
+	ImportStmt struct {
+		Import Pos
+		Path   *BasicLit // string
+		As     Pos
+		Name   *Name
+	}
+
+	ThrowStmt struct {
+		Throw  Pos
+		Lparen Pos
+		Value  Expr
+		Rparen Pos
+	}
+)
+
+func (s *Block) Pos() Pos { return s.Lbrace }
+
+func (s *AssignStmt) Pos() Pos      { return s.Target.Pos() }
+func (s *MultiAssignStmt) Pos() Pos { return s.Targets[0].Pos() }
+func (s *CallStmt) Pos() Pos        { return s.Call.Pos() }
+func (s *BuiltinStmt) Pos() Pos     { return s.Call.Pos() }
+func (s *PrintStmt) Pos() Pos       { return s.At }
+func (s *IfStmt) Pos() Pos          { return s.If }
+func (s *ForStmt) Pos() Pos         { return s.For }
+func (s *ForInStmt) Pos() Pos       { return s.For }
+func (s *WhileStmt) Pos() Pos       { return s.While }
+func (s *ReturnStmt) Pos() Pos      { return s.Return }
+func (s *TryStmt) Pos() Pos         { return s.Try }
+func (s *FuncDecl) Pos() Pos        { return s.Func }
+func (s *ImportStmt) Pos() Pos      { return s.Import }
+func (s *ThrowStmt) Pos() Pos       { return s.Throw }
+
+func (*AssignStmt) stmtNode()      {}
+func (*MultiAssignStmt) stmtNode() {}
+func (*CallStmt) stmtNode()        {}
+func (*BuiltinStmt) stmtNode()     {}
+func (*PrintStmt) stmtNode()       {}
+func (*IfStmt) stmtNode()          {}
+func (*ForStmt) stmtNode()         {}
+func (*ForInStmt) stmtNode()       {}
+func (*WhileStmt) stmtNode()       {}
+func (*ReturnStmt) stmtNode()      {}
+func (*TryStmt) stmtNode()         {}
+func (*ThrowStmt) stmtNode()       {}
+func (*FuncDecl) stmtNode()        {}
+func (*ImportStmt) stmtNode()      {}
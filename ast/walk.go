@@ -0,0 +1,121 @@
+package ast
+
+// Inspect traverses the syntax tree rooted at node in depth-first order. It
+// calls f(node) first; if f returns true, Inspect continues with the children
+// of node. Comments are not visited.
+func Inspect(node Node, f func(Node) bool) {
+	if node == nil || !f(node) {
+		return
+	}
+	switch n := node.(type) {
+	case *File:
+		for _, s := range n.Stmts {
+			Inspect(s, f)
+		}
+	case *Name:
+		for _, x := range n.Index {
+			Inspect(x, f)
+		}
+	case *RecordLit:
+		for _, x := range n.Fields {
+			Inspect(x.Value, f)
+		}
+	case *FuncLit:
+		for _, x := range n.Params {
+			Inspect(x, f)
+		}
+		for _, x := range n.Defaults {
+			if x != nil {
+				Inspect(x, f)
+			}
+		}
+		Inspect(n.Body, f)
+	case *UnaryExpr:
+		Inspect(n.X, f)
+	case *BinaryExpr:
+		Inspect(n.X, f)
+		Inspect(n.Y, f)
+	case *BuiltinCall:
+		for _, x := range n.Args {
+			Inspect(x, f)
+		}
+	case *CondExpr:
+		Inspect(n.Cond, f)
+		Inspect(n.X, f)
+		Inspect(n.Y, f)
+	case *ParenExpr:
+		Inspect(n.X, f)
+	case *CallExpr:
+		Inspect(n.Fun, f)
+		for _, x := range n.Args {
+			Inspect(x, f)
+		}
+	case *Block:
+		for _, s := range n.Stmts {
+			Inspect(s, f)
+		}
+	case *AssignStmt:
+		Inspect(n.Target, f)
+		Inspect(n.Value, f)
+	case *MultiAssignStmt:
+		for _, x := range n.Targets {
+			Inspect(x, f)
+		}
+		for _, x := range n.Values {
+			Inspect(x, f)
+		}
+	case *CallStmt:
+		Inspect(n.Call, f)
+	case *BuiltinStmt:
+		Inspect(n.Call, f)
+	case *PrintStmt:
+		for _, x := range n.Args {
+			Inspect(x, f)
+		}
+	case *IfStmt:
+		Inspect(n.Cond, f)
+		Inspect(n.Body, f)
+		if n.Else != nil {
+			Inspect(n.Else, f)
+		}
+	case *ForStmt:
+		if n.Init != nil {
+			Inspect(n.Init, f)
+		}
+		Inspect(n.Cond, f)
+		if n.Post != nil {
+			Inspect(n.Post, f)
+		}
+		Inspect(n.Body, f)
+	case *ForInStmt:
+		Inspect(n.Var, f)
+		Inspect(n.X, f)
+		Inspect(n.Body, f)
+	case *WhileStmt:
+		Inspect(n.Cond, f)
+		Inspect(n.Body, f)
+	case *ReturnStmt:
+		for _, x := range n.Values {
+			Inspect(x, f)
+		}
+	case *TryStmt:
+		Inspect(n.Body, f)
+		if n.Param != nil {
+			Inspect(n.Param, f)
+		}
+		if n.Catch != nil {
+			Inspect(n.Catch, f)
+		}
+		if n.Finally != nil {
+			Inspect(n.Finally, f)
+		}
+	case *FuncDecl:
+		Inspect(n.Name, f)
+		Inspect(n.Fun, f)
+	case *ImportStmt:
+		Inspect(n.Path, f)
+		Inspect(n.Name, f)
+	case *ThrowStmt:
+		Inspect(n.Value, f)
+	}
+}
@@ -0,0 +1,90 @@
+package yail
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type benchProgram struct {
+	name, source string
+}
+
+// returns programs of the benchmark corpus sorted by name
+func benchCorpus(b *testing.B) []benchProgram {
+	files, err := filepath.Glob(filepath.Join("testdata", "bench", "*.yail"))
+	if err != nil || len(files) == 0 {
+		b.Fatal("benchmark corpus not found", err)
+	}
+	corpus := make([]benchProgram, len(files))
+	for i, name := range files {
+		source, err := ioutil.ReadFile(name)
+		if err != nil {
+			b.Fatal(err)
+		}
+		corpus[i] = benchProgram{strings.TrimSuffix(filepath.Base(name), ".yail"), string(source)}
+	}
+	return corpus
+}
+
+func BenchmarkLexer(b *testing.B) {
+	for _, p := range benchCorpus(b) {
+		source := p.source
+		b.Run(p.name, func(b *testing.B) {
+			b.SetBytes(int64(len(source)))
+			for n := 0; n < b.N; n++ {
+				l := newLexer(source, false)
+				for l.get().typ != lexEof {
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkParser(b *testing.B) {
+	for _, p := range benchCorpus(b) {
+		source := p.source
+		b.Run(p.name, func(b *testing.B) {
+			b.SetBytes(int64(len(source)))
+			for n := 0; n < b.N; n++ {
+				parseFile(source, false)
+			}
+		})
+	}
+}
+
+func BenchmarkCompile(b *testing.B) {
+	for _, p := range benchCorpus(b) {
+		source := p.source
+		b.Run(p.name, func(b *testing.B) {
+			b.SetBytes(int64(len(source)))
+			for n := 0; n < b.N; n++ {
+				if _, err := Compile(source, nil); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkInterpreter(b *testing.B) {
+	for _, p := range benchCorpus(b) {
+		source := p.source
+		prog, err := Compile(source, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(p.name, func(b *testing.B) {
+			var ops uint64
+			for n := 0; n < b.N; n++ {
+				n, err := prog.RunCount(strings.NewReader(""), ioutil.Discard)
+				if err != nil {
+					b.Fatal(err)
+				}
+				ops += n
+			}
+			b.ReportMetric(float64(ops)/b.Elapsed().Seconds(), "ops/s")
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package yail
+
+// This file contains integer arithmetic which promotes results overflowing
+// int64 to arbitrary-precision integers. An integer value is either int64 or,
+// if it does not fit, *big.Int.
+
+import (
+	"math"
+	"math/big"
+)
+
+// returns a+b and false if the result overflows
+func addInt(a, b int64) (int64, bool) {
+	c := a + b
+	return c, (c > a) == (b > 0)
+}
+
+func subInt(a, b int64) (int64, bool) {
+	c := a - b
+	return c, (c < a) == (b > 0)
+}
+
+func mulInt(a, b int64) (int64, bool) {
+	if a == 0 || b == 0 {
+		return 0, true
+	}
+	c := a * b
+	return c, c/b == a && !(a == -1 && b == math.MinInt64) && !(b == -1 && a == math.MinInt64)
+}
+
+func shlInt(a, b int64) (int64, bool) {
+	c := a << uint64(b)
+	return c, c>>uint64(b) == a
+}
+
+func isInt(v interface{}) bool {
+	switch v.(type) {
+	case int64, *big.Int:
+		return true
+	}
+	return false
+}
+
+func isNumber(v interface{}) bool {
+	_, ok := v.(float64)
+	return ok || isInt(v)
+}
+
+func toBig(v interface{}) *big.Int {
+	switch v := v.(type) {
+	case int64:
+		return big.NewInt(v)
+	case *big.Int:
+		return v
+	}
+	return new(big.Int)
+}
+
+func toFloat(v interface{}) float64 {
+	switch v := v.(type) {
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	case *big.Int:
+		f, _ := new(big.Float).SetInt(v).Float64()
+		return f
+	}
+	return 0
+}
+
+// converts x to int64 if it fits
+func normalize(x *big.Int) interface{} {
+	if x.IsInt64() {
+		return x.Int64()
+	}
+	return x
+}
+
+// applies an integer operator to integers a and b; the result is computed
+// by fb if any of them is big or fi reports an overflow
+func intArith(a, b interface{}, fi intF, fb bigF) interface{} {
+	if a, ok := a.(int64); ok {
+		if b, ok := b.(int64); ok {
+			if c, ok := fi(a, b); ok {
+				return c
+			}
+		}
+	}
+	return normalize(fb(new(big.Int), toBig(a), toBig(b)))
+}
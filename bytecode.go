@@ -0,0 +1,459 @@
+package yail
+
+// This file contains a binary format for storing compiled programs.
+//
+// A file starts with a header (magic string and format version) followed by
+// a constant pool, a function table and a module table. The main function is
+// the first one in the table, nested functions follow it. Every op is stored as
+// its type and a tagged parameter which is either inline (int), an index to the
+// constant pool, an index to the function table, an index to the module table
+// or a list of local variable names. A module is stored as its name in the
+// constant pool and its function in the function table.
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+
+	"github.com/mabu/yail/ast"
+)
+
+const (
+	bytecodeMagic   = "YAILC"
+	bytecodeVersion = 16 // increase when op types or their params change
+)
+
+// op parameter tags
+const (
+	paramNone     byte = iota
+	paramInt           // param: varint
+	paramConst         // param: index to the constant pool
+	paramFunction      // param: index to the function table
+	paramFrame         // param: number of slots and indices of their names in the constant pool
+	paramPos           // param: line and column
+	paramArity         // param: minimal and maximal (or -1) number of arguments
+	paramModule        // param: index to the module table
+)
+
+// constant pool tags
+const (
+	constInt byte = iota
+	constFloat
+	constBool
+	constString
+	constBig // integer which does not fit int64, in decimal
+)
+
+type encoder struct {
+	w      *bufio.Writer
+	consts []interface{}
+	index  map[interface{}]int
+	floats map[uint64]int // by bits: NaN != NaN and -0.0 == 0.0
+	funs   []function
+	refs   [][]int // function table indices of the nested functions
+	mods   []*module
+	modIdx map[*module]int
+	modFun []int // function table indices of the modules
+}
+
+// Writes the program in binary bytecode format to w.
+func (p *Program) Encode(w io.Writer) error {
+	e := encoder{w: bufio.NewWriter(w), index: make(map[interface{}]int), floats: make(map[uint64]int), modIdx: make(map[*module]int)}
+	if _, err := e.collect(p.main); err != nil {
+		return err
+	}
+	e.w.WriteString(bytecodeMagic)
+	e.uvarint(bytecodeVersion)
+	e.uvarint(uint64(len(e.consts)))
+	for _, c := range e.consts {
+		switch c := c.(type) {
+		case int64:
+			e.w.WriteByte(constInt)
+			e.varint(c)
+		case float64:
+			e.w.WriteByte(constFloat)
+			var buf [8]byte
+			binary.LittleEndian.PutUint64(buf[:], math.Float64bits(c))
+			e.w.Write(buf[:])
+		case bool:
+			e.w.WriteByte(constBool)
+			if c {
+				e.w.WriteByte(1)
+			} else {
+				e.w.WriteByte(0)
+			}
+		case string:
+			e.w.WriteByte(constString)
+			e.uvarint(uint64(len(c)))
+			e.w.WriteString(c)
+		case *big.Int:
+			s := c.String()
+			e.w.WriteByte(constBig)
+			e.uvarint(uint64(len(s)))
+			e.w.WriteString(s)
+		}
+	}
+	e.uvarint(uint64(len(e.funs)))
+	for i, f := range e.funs {
+		refs := e.refs[i]
+		e.uvarint(uint64(len(f)))
+		for _, o := range f {
+			e.uvarint(uint64(o.typ))
+			switch param := o.param.(type) {
+			case nil:
+				e.w.WriteByte(paramNone)
+			case int:
+				e.w.WriteByte(paramInt)
+				e.varint(int64(param))
+			case function:
+				e.w.WriteByte(paramFunction)
+				e.uvarint(uint64(refs[0]))
+				refs = refs[1:]
+			case *module:
+				e.w.WriteByte(paramModule)
+				e.uvarint(uint64(e.modIdx[param]))
+			case arity:
+				e.w.WriteByte(paramArity)
+				e.varint(int64(param.min))
+				e.varint(int64(param.max))
+			case ast.Pos:
+				e.w.WriteByte(paramPos)
+				e.uvarint(uint64(param.Line))
+				e.uvarint(uint64(param.Col))
+			case *frame:
+				e.w.WriteByte(paramFrame)
+				e.uvarint(uint64(len(param.names)))
+				for _, name := range param.names {
+					e.uvarint(uint64(e.index[name]))
+				}
+			default:
+				e.w.WriteByte(paramConst)
+				e.uvarint(uint64(e.constIndex(param)))
+			}
+		}
+	}
+	e.uvarint(uint64(len(e.mods)))
+	for k, m := range e.mods {
+		e.uvarint(uint64(e.index[m.name]))
+		e.uvarint(uint64(e.modFun[k]))
+	}
+	return e.w.Flush()
+}
+
+// collect adds f and its nested functions to the function table (in
+// preorder) and their constants to the constant pool. Modules imported by f
+// are added to the module table the first time they are seen. Returns the
+// index of f.
+func (e *encoder) collect(f function) (int, error) {
+	idx := len(e.funs)
+	e.funs = append(e.funs, f)
+	e.refs = append(e.refs, nil)
+	for _, o := range f {
+		switch param := o.param.(type) {
+		case nil, int, ast.Pos, arity:
+		case function:
+			child, err := e.collect(param)
+			if err != nil {
+				return 0, err
+			}
+			e.refs[idx] = append(e.refs[idx], child)
+		case *module:
+			if _, ok := e.modIdx[param]; ok {
+				break
+			}
+			e.modIdx[param] = len(e.mods)
+			e.mods = append(e.mods, param)
+			e.modFun = append(e.modFun, 0)
+			e.constant(param.name)
+			child, err := e.collect(param.f)
+			if err != nil {
+				return 0, err
+			}
+			e.modFun[e.modIdx[param]] = child
+		case *frame:
+			for _, name := range param.names {
+				e.constant(name)
+			}
+		case int64, float64, bool, string, *big.Int:
+			e.constant(param)
+		default:
+			return 0, fmt.Errorf("cannot encode param %#v of op %d", param, o.typ)
+		}
+	}
+	return idx, nil
+}
+
+// adds a value to the constant pool
+func (e *encoder) constant(c interface{}) {
+	if f, ok := c.(float64); ok {
+		if _, ok := e.floats[math.Float64bits(f)]; !ok {
+			e.floats[math.Float64bits(f)] = len(e.consts)
+			e.consts = append(e.consts, c)
+		}
+	} else if _, ok := e.index[c]; !ok {
+		e.index[c] = len(e.consts)
+		e.consts = append(e.consts, c)
+	}
+}
+
+// returns the index of a value in the constant pool
+func (e *encoder) constIndex(c interface{}) int {
+	if f, ok := c.(float64); ok {
+		return e.floats[math.Float64bits(f)]
+	}
+	return e.index[c]
+}
+
+func (e *encoder) uvarint(x uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	e.w.Write(buf[:binary.PutUvarint(buf[:], x)])
+}
+
+func (e *encoder) varint(x int64) {
+	var buf [binary.MaxVarintLen64]byte
+	e.w.Write(buf[:binary.PutVarint(buf[:], x)])
+}
+
+var errBadBytecode = errors.New("malformed bytecode")
+
+type decoder struct {
+	r   *bufio.Reader
+	err error
+}
+
+// Reads a program in binary bytecode format written by Encode.
+func Decode(r io.Reader) (*Program, error) {
+	d := decoder{r: bufio.NewReader(r)}
+	magic := make([]byte, len(bytecodeMagic))
+	if _, err := io.ReadFull(d.r, magic); err != nil || string(magic) != bytecodeMagic {
+		return nil, errors.New("not a YAIL bytecode file")
+	}
+	if v := d.uvarint(); d.err == nil && v != bytecodeVersion {
+		return nil, fmt.Errorf("unsupported bytecode version %d (expected %d)", v, bytecodeVersion)
+	}
+	consts := make([]interface{}, d.count())
+	for i := 0; i < len(consts) && d.err == nil; i++ {
+		switch d.byte() {
+		case constInt:
+			consts[i] = d.varint()
+		case constFloat:
+			var buf [8]byte
+			d.read(buf[:])
+			consts[i] = math.Float64frombits(binary.LittleEndian.Uint64(buf[:]))
+		case constBool:
+			consts[i] = d.byte() != 0
+		case constString:
+			buf := make([]byte, d.count())
+			d.read(buf)
+			consts[i] = string(buf)
+		case constBig:
+			buf := make([]byte, d.count())
+			d.read(buf)
+			if b, ok := new(big.Int).SetString(string(buf), 10); ok {
+				consts[i] = b
+			} else {
+				d.fail()
+			}
+		default:
+			d.fail()
+		}
+	}
+	funs := make([]function, d.count())
+	for i := 0; i < len(funs) && d.err == nil; i++ {
+		funs[i] = make(function, d.count())
+		for j := 0; j < len(funs[i]) && d.err == nil; j++ {
+			o := &funs[i][j]
+			if o.typ = opType(d.uvarint()); o.typ >= numOps {
+				d.fail()
+			}
+			switch d.byte() {
+			case paramNone:
+			case paramInt:
+				o.param = int(d.varint())
+			case paramConst:
+				if k := d.uvarint(); k < uint64(len(consts)) {
+					o.param = consts[k]
+				} else {
+					d.fail()
+				}
+			case paramFunction:
+				// Functions only refer to the ones after them, so the
+				// table can not contain cycles.
+				if k := d.uvarint(); k > uint64(i) && k < uint64(len(funs)) {
+					o.param = k
+				} else {
+					d.fail()
+				}
+			case paramFrame:
+				names := make([]string, d.count())
+				for k := range names {
+					if c := d.uvarint(); c < uint64(len(consts)) {
+						var ok bool
+						if names[k], ok = consts[c].(string); !ok {
+							d.fail()
+						}
+					} else {
+						d.fail()
+					}
+				}
+				o.param = newFrame(names)
+			case paramPos:
+				o.param = ast.Pos{Line: d.count(), Col: d.count()}
+			case paramArity:
+				o.param = arity{int(d.varint()), int(d.varint())}
+			case paramModule:
+				o.param = moduleIndex(d.uvarint())
+			default:
+				d.fail()
+			}
+		}
+	}
+	mods := make([]*module, d.count())
+	for k := 0; k < len(mods) && d.err == nil; k++ {
+		mods[k] = new(module)
+		if c := d.uvarint(); c < uint64(len(consts)) {
+			var ok bool
+			if mods[k].name, ok = consts[c].(string); !ok {
+				d.fail()
+			}
+		} else {
+			d.fail()
+		}
+		// A module may refer to any function: cycles are found when it
+		// is imported.
+		if f := d.uvarint(); f < uint64(len(funs)) {
+			mods[k].f = funs[f]
+		} else {
+			d.fail()
+		}
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	if len(funs) == 0 {
+		return nil, errBadBytecode
+	}
+	for _, f := range funs {
+		if !valid(f) {
+			return nil, errBadBytecode
+		}
+		for j, o := range f {
+			switch k := o.param.(type) {
+			case uint64:
+				f[j].param = funs[k]
+			case moduleIndex:
+				if int(k) >= len(mods) {
+					return nil, errBadBytecode
+				}
+				f[j].param = mods[k]
+			}
+		}
+	}
+	return &Program{funs[0]}, nil
+}
+
+// valid reports whether the jumps of a decoded function stay inside it, its
+// local variables are in the slots allocated by opFrame at its start and
+// the counts of values taken by its ops are sane: each value is pushed by an
+// op of the function.
+func valid(f function) bool {
+	slots := 0
+	for ic, o := range f {
+		n, isInt := o.param.(int)
+		if isJump(o.typ) {
+			if t := ic + n; !isInt || t < 0 || t > len(f) {
+				return false
+			}
+			continue
+		}
+		switch o.typ {
+		case opFrame: // a jump could skip it anywhere else
+			fr, ok := o.param.(*frame)
+			if !ok || ic > 0 {
+				return false
+			}
+			slots = len(fr.names)
+		case opLoadSlot, opStoreSlot:
+			if !isInt || n < 0 || n >= slots {
+				return false
+			}
+		case opRange:
+			if !isInt || n < 1 || n > 3 {
+				return false
+			}
+		case opCall, opCallMulti, opPrint, opPrintLn, opReturn, opPack, opUnpack, opRecord, opOpen, opEndTry:
+			if !isInt || n < 0 || n > len(f) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// index to the module table, replaced by the module when it is decoded
+type moduleIndex uint64
+
+func (d *decoder) fail() {
+	if d.err == nil {
+		d.err = errBadBytecode
+	}
+}
+
+func (d *decoder) check(err error) {
+	if err != nil && d.err == nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		d.err = err
+	}
+}
+
+func (d *decoder) byte() byte {
+	if d.err != nil {
+		return 0
+	}
+	b, err := d.r.ReadByte()
+	d.check(err)
+	return b
+}
+
+func (d *decoder) read(buf []byte) {
+	if d.err != nil {
+		return
+	}
+	_, err := io.ReadFull(d.r, buf)
+	d.check(err)
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	x, err := binary.ReadUvarint(d.r)
+	d.check(err)
+	return x
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	x, err := binary.ReadVarint(d.r)
+	d.check(err)
+	return x
+}
+
+// count reads a length and makes sure it is sane before anything is
+// allocated for it.
+func (d *decoder) count() int {
+	n := d.uvarint()
+	if n > 1<<24 {
+		d.fail()
+		return 0
+	}
+	return int(n)
+}
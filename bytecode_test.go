@@ -0,0 +1,151 @@
+package yail
+
+import (
+	"bytes"
+	"math"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestBytecodeRoundTrip(t *testing.T) {
+	source := `fun = (x) {
+		g = () { return "nested" }
+		if x == 0 {
+			return 1
+		} else {
+			return x * .fun(x - 1)
+		}
+	}
+	h = (a, b) { return a || b && 2.5 > 1 }
+	@println(fun(5), "x", -3)`
+	expect := parse(source)
+	var buf bytes.Buffer
+	if err := (&Program{expect}).Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	prog, err := Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testFun(t, prog.main, expect)
+}
+
+func TestBytecodeFrame(t *testing.T) {
+	prog, err := Compile(`f = (a, b) { c = a + b; return c }
+	@println(f(1, 2))`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := prog.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testFun(t, decoded.main, prog.main)
+}
+
+func TestBytecodeBigInt(t *testing.T) {
+	expect := parse(`@println(100000000000000000000 * 2)`)
+	var buf bytes.Buffer
+	if err := (&Program{expect}).Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	prog, err := Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := prog.main[0].param.(*big.Int); got.Cmp(expect[0].param.(*big.Int)) != 0 {
+		t.Errorf("Got %v, expected %v.", got, expect[0].param)
+	}
+	testFun(t, prog.main[1:], expect[1:])
+}
+
+func TestBytecodeFloats(t *testing.T) {
+	expect := []float64{math.NaN(), math.Copysign(0, -1), 0, math.NaN()}
+	f := make(function, len(expect))
+	for k, c := range expect {
+		f[k] = op{opFloat, c}
+	}
+	var buf bytes.Buffer
+	if err := (&Program{f}).Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	prog, err := Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, o := range prog.main {
+		if got := o.param.(float64); math.Float64bits(got) != math.Float64bits(expect[k]) {
+			t.Errorf("Constant %d: got %v, expected %v.", k, got, expect[k])
+		}
+	}
+}
+
+func TestBytecodeEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	if err := (&Program{function{}}).Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	prog, err := Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testFun(t, prog.main, function{})
+}
+
+func TestBytecodeMalformed(t *testing.T) {
+	var buf bytes.Buffer
+	if err := (&Program{parse(`f = (x) { return x + 1 }`)}).Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	valid := buf.Bytes()
+	for i := 0; i < len(valid); i++ {
+		if _, err := Decode(bytes.NewReader(valid[:i])); err == nil {
+			t.Errorf("Decoding %d of %d bytes did not fail.", i, len(valid))
+		}
+	}
+	if _, err := Decode(bytes.NewReader([]byte("YAILC\x63"))); err == nil {
+		t.Error("Decoding unsupported version did not fail.")
+	}
+	for _, f := range []function{
+		{{opJmp, 2}},
+		{{opInt, int64(1)}, {opJmpFalse, -2}},
+		{{opTry, "x"}},
+		{{opLoadSlot, 0}},
+		{{opFrame, newFrame([]string{"a"})}, {opStoreSlot, 1}},
+		{{opFrame, int64(1)}},
+		{{opJmp, 2}, {opFrame, newFrame([]string{"a"})}, {opLoadSlot, 0}},
+		{{opPrintLn, -1}},
+		{{opPack, -1}},
+		{{opPack, 1 << 40}},
+		{{opEndTry, 5}},
+		{{opRange, 4}},
+	} {
+		var buf bytes.Buffer
+		if err := (&Program{f}).Encode(&buf); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Decode(&buf); err == nil {
+			t.Errorf("Decoding %v did not fail.", f)
+		}
+	}
+}
+
+func TestBytecodeEndTry(t *testing.T) {
+	var buf bytes.Buffer
+	if err := (&Program{function{{opInt, int64(1)}, {opEndTry, 1}}}).Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	prog, err := Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := "Runtime error: opEndTry failed: not enough error handlers."
+	if err := prog.Run(strings.NewReader(""), new(bytes.Buffer)); err == nil || err.Error() != msg {
+		t.Errorf("Got error %v, expected %q.", err, msg)
+	}
+}
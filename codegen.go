@@ -0,0 +1,512 @@
+package yail
+
+// This file contains a code generator. It lowers a syntax tree to a bytecode.
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/mabu/yail/ast"
+)
+
+var builtinCallOp = map[string]opType{
+	"type":       opTypeOf,
+	"defined":    opDefined,
+	"int":        opToInt,
+	"float":      opToFloat,
+	"str":        opToStr,
+	"bool":       opToBool,
+	"@read":      opFileRead,
+	"@write":     opFileWrite,
+	"@close":     opClose,
+	"@readLines": opReadLines,
+}
+
+var binaryOp = map[string]opType{
+	"||": opOr,
+	"&&": opAnd,
+	"==": opEq,
+	"!=": opNeq,
+	"<":  opLess,
+	">":  opGreater,
+	"<=": opLeq,
+	">=": opGeq,
+	"+":  opSum,
+	"-":  opSub,
+	"*":  opMul,
+	"/":  opDiv,
+	"%":  opMod,
+	"&":  opBitAnd,
+	"|":  opBitOr,
+	"^":  opBitXor,
+	"<<": opShl,
+	">>": opShr,
+}
+
+var unaryOp = map[string]opType{
+	"-": opNeg,
+	"!": opNot,
+	"~": opBitNot,
+}
+
+var builtinOp = map[string]opType{
+	"@int":   opReadInt,
+	"@float": opReadFloat,
+	"@line":  opReadLine,
+	"@char":  opReadChar,
+	"@rnd":   opRnd,
+}
+
+type generator struct {
+	imports map[*ast.ImportStmt]*module
+	slots   bool           // resolve local variables to slots
+	lines   bool           // emit opLine before statements and opCallee before calls
+	frame   *frame         // local variables of the current function
+	depth   int            // number of error handlers in the current function
+	finally []finallyBlock // to be run before return
+}
+
+// a finally block of an enclosing try statement
+type finallyBlock struct {
+	stmts []ast.Stmt
+	depth int // number of error handlers outside the try statement
+}
+
+// imports are the modules imported by the file
+func gen(file *ast.File, imports map[*ast.ImportStmt]*module, slots, lines bool) function {
+	g := generator{imports: imports, slots: slots, lines: lines}
+	return g.function("", nil, file.Stmts)
+}
+
+// name is empty unless the function is declared by func, fun is nil for the
+// main program
+func (g *generator) function(name string, fun *ast.FuncLit, stmts []ast.Stmt) function {
+	var params []*ast.Name
+	if fun != nil {
+		params = fun.Params
+	}
+	outer, depth, finally := g.frame, g.depth, g.finally
+	defer func() { g.frame, g.depth, g.finally = outer, depth, finally }()
+	f := make(function, 0, len(params))
+	g.frame, g.depth, g.finally = nil, 0, nil
+	if g.slots {
+		if fr := resolve(params, stmts); len(fr.names) > 0 {
+			g.frame = fr
+			f = append(f, op{opFrame, fr})
+		}
+	}
+	if name != "" && g.lines {
+		f = append(f, op{opName, name})
+	}
+	if fun != nil {
+		f = g.params(f, fun)
+	}
+	return g.stmts(f, stmts)
+}
+
+// checks the number of arguments and stores them to the parameters
+func (g *generator) params(f function, fun *ast.FuncLit) function {
+	a := arity{len(fun.Params), len(fun.Params)}
+	params := fun.Params
+	if fun.Ellipsis.IsValid() {
+		params = params[:len(params)-1]
+		a = arity{len(params), -1}
+	}
+	for k := range params {
+		if fun.Defaults != nil && fun.Defaults[k] != nil {
+			a.min = k
+			break
+		}
+	}
+	f = append(f, op{opArgs, a})
+	for k, p := range params {
+		if fun.Defaults != nil && fun.Defaults[k] != nil {
+			def := g.expr(make(function, 0), fun.Defaults[k])
+			f = append(f, op{opJmpArg, len(def) + 1})
+			f = append(f, def...)
+		}
+		if k, ok := g.slot(p); ok {
+			f = append(f, op{opStoreSlot, k})
+		} else {
+			f = append(f, op{opStore, p.Name})
+		}
+	}
+	if fun.Ellipsis.IsValid() {
+		f = append(f, op{opRest, fun.Params[len(fun.Params)-1].Name})
+	}
+	return f
+}
+
+// returns slot of a local variable
+func (g *generator) slot(n *ast.Name) (int, bool) {
+	if g.frame == nil || !isLocal(n) {
+		return 0, false
+	}
+	k, ok := g.frame.index[n.Name]
+	return k, ok
+}
+
+// function declarations are hoisted: they come before the other statements
+func (g *generator) stmts(f function, stmts []ast.Stmt) function {
+	for _, hoist := range []bool{true, false} {
+		for _, s := range stmts {
+			if _, ok := s.(*ast.FuncDecl); ok != hoist {
+				continue
+			}
+			if g.lines {
+				f = append(f, op{opLine, s.Pos()})
+			}
+			f = g.stmt(f, s)
+		}
+	}
+	return f
+}
+
+func (g *generator) stmt(f function, s ast.Stmt) function {
+	switch s := s.(type) {
+	case *ast.AssignStmt:
+		f = g.assign(f, s)
+	case *ast.MultiAssignStmt:
+		f = g.multiAssign(f, s)
+	case *ast.CallStmt:
+		f = g.multiValue(f, s.Call)
+		f = append(f, op{opPop, nil}) // ignore return value
+	case *ast.BuiltinStmt:
+		f = g.expr(f, s.Call)
+		f = append(f, op{opPop, nil})
+	case *ast.PrintStmt:
+		for _, x := range s.Args {
+			f = g.expr(f, x)
+		}
+		if s.NewLine {
+			f = append(f, op{opPrintLn, len(s.Args)})
+		} else {
+			f = append(f, op{opPrint, len(s.Args)})
+		}
+	case *ast.ReturnStmt:
+		switch len(s.Values) {
+		case 0:
+			f = g.unwind(f)
+			f = append(f, op{opReturn, 0})
+		case 1:
+			f = g.multiValue(f, s.Values[0])
+			f = g.unwind(f)
+			f = append(f, op{opReturn, 1})
+		default:
+			for _, x := range s.Values {
+				f = g.expr(f, x)
+			}
+			f = append(f, op{opPack, len(s.Values)})
+			f = g.unwind(f)
+			f = append(f, op{opReturn, 1})
+		}
+	case *ast.TryStmt:
+		f = g.try(f, s)
+	case *ast.ImportStmt:
+		f = append(f, op{opImport, g.imports[s]}, op{opDeclare, s.Name.Name})
+	case *ast.ThrowStmt:
+		f = g.expr(f, s.Value)
+		f = append(f, op{opThrow, nil})
+	case *ast.FuncDecl:
+		f = append(f, op{opFunction, g.function(s.Name.Name, s.Fun, s.Fun.Body.Stmts)})
+		f = append(f, op{opDeclare, s.Name.Name})
+	case *ast.IfStmt:
+		f = g.expr(f, s.Cond)
+		body := g.stmts(make(function, 0), s.Body.Stmts)
+		elseBody := make(function, 0)
+		if s.Else != nil {
+			elseBody = g.stmts(elseBody, s.Else.Stmts)
+			body = append(body, op{opJmp, len(elseBody) + 1})
+		}
+		f = append(f, op{opJmpFalse, len(body) + 1})
+		f = append(f, body...)
+		f = append(f, elseBody...)
+	case *ast.ForStmt:
+		if s.Init != nil {
+			f = g.assign(f, s.Init)
+		}
+		start := len(f)
+		f = g.expr(f, s.Cond)
+		after := make(function, 0)
+		if s.Post != nil {
+			after = g.assign(after, s.Post)
+		}
+		body := g.stmts(make(function, 0), s.Body.Stmts)
+		f = append(f, op{opJmpFalse, len(body) + len(after) + 2})
+		f = append(f, body...)
+		f = append(f, after...)
+		f = append(f, op{opJmp, start - len(f)})
+	case *ast.ForInStmt:
+		f = g.expr(f, s.X)
+		f = append(f, op{opIter, nil})
+		start := len(f)
+		body := g.store(make(function, 0), s.Var)
+		body = g.stmts(body, s.Body.Stmts)
+		f = append(f, op{opNext, len(body) + 2})
+		f = append(f, body...)
+		f = append(f, op{opJmp, start - len(f)})
+	case *ast.WhileStmt:
+		start := len(f)
+		f = g.expr(f, s.Cond)
+		body := g.stmts(make(function, 0), s.Body.Stmts)
+		f = append(f, op{opJmpFalse, len(body) + 2})
+		f = append(f, body...)
+		f = append(f, op{opJmp, start - len(f)})
+	default:
+		panic("unknown statement")
+	}
+	return f
+}
+
+// Layout of try B catch (e) C finally F:
+//
+//	    opTry H1
+//	    B
+//	    opEndTry
+//	    F
+//	    opJmp END
+//	H1: opTry H2
+//	    store e
+//	    C
+//	    opEndTry
+//	    F
+//	    opJmp END
+//	H2: F
+//	    opThrow
+//	END:
+//
+// Without finally the catch part ends at H2; without catch H1 is like H2.
+func (g *generator) try(f function, s *ast.TryStmt) function {
+	depth, finally := g.depth, g.finally
+	defer func() { g.depth, g.finally = depth, finally }()
+	var fin function // F
+	if s.Finally != nil {
+		g.depth = depth
+		fin = g.stmts(make(function, 0), s.Finally.Stmts)
+		g.finally = append(finally, finallyBlock{s.Finally.Stmts, depth})
+	}
+	g.depth = depth + 1
+	body := g.stmts(make(function, 0), s.Body.Stmts)
+	body = append(body, op{opEndTry, depth})
+	body = append(body, fin...)
+	var catch function // from H1 to END
+	if s.Catch != nil {
+		if s.Finally == nil {
+			g.depth = depth
+		}
+		if s.Param == nil {
+			catch = append(catch, op{opPop, nil})
+		} else if k, ok := g.slot(s.Param); ok {
+			catch = append(catch, op{opStoreSlot, k})
+		} else {
+			catch = append(catch, op{opStore, s.Param.Name})
+		}
+		catch = g.stmts(catch, s.Catch.Stmts)
+	}
+	if s.Finally != nil {
+		rethrow := append(fin[:len(fin):len(fin)], op{opThrow, nil})
+		if s.Catch != nil {
+			catch = append(catch, op{opEndTry, depth})
+			catch = append(catch, fin...)
+			catch = append(catch, op{opJmp, len(rethrow) + 1})
+			catch = append(function{{opTry, len(catch) + 1}}, catch...)
+		}
+		catch = append(catch, rethrow...)
+	}
+	body = append(body, op{opJmp, len(catch) + 1})
+	f = append(f, op{opTry, len(body) + 1})
+	f = append(f, body...)
+	return append(f, catch...)
+}
+
+// runs finally blocks of the enclosing try statements before return
+func (g *generator) unwind(f function) function {
+	depth, finally := g.depth, g.finally
+	for k := len(finally) - 1; k >= 0; k-- {
+		g.depth, g.finally = finally[k].depth, finally[:k]
+		f = append(f, op{opEndTry, finally[k].depth})
+		f = g.stmts(f, finally[k].stmts)
+	}
+	g.depth, g.finally = depth, finally
+	return f
+}
+
+func (g *generator) assign(f function, s *ast.AssignStmt) function {
+	if r, field, ok := splitField(s.Target); ok {
+		f = g.expr(f, r)
+		f = g.expr(f, s.Value)
+		return append(f, op{opSetField, field})
+	}
+	if k, ok := g.slot(s.Target); ok {
+		f = g.expr(f, s.Value)
+		return append(f, op{opStoreSlot, k})
+	}
+	f = g.name(f, s.Target)
+	f = g.expr(f, s.Value)
+	return append(f, op{opStoreStr, nil})
+}
+
+// compiles an expression whose value is returned, unpacked or ignored: if it
+// is a call, the function may return several values
+func (g *generator) multiValue(f function, x ast.Expr) function {
+	f = g.expr(f, x)
+	for p, ok := x.(*ast.ParenExpr); ok; p, ok = x.(*ast.ParenExpr) {
+		x = p.X
+	}
+	if _, ok := x.(*ast.CallExpr); ok {
+		f[len(f)-1].typ = opCallMulti
+	}
+	return f
+}
+
+// all values are computed before the first one is stored
+func (g *generator) multiAssign(f function, s *ast.MultiAssignStmt) function {
+	if len(s.Values) == 1 {
+		f = g.multiValue(f, s.Values[0])
+	} else {
+		for _, x := range s.Values {
+			f = g.expr(f, x)
+		}
+		f = append(f, op{opPack, len(s.Values)})
+	}
+	f = append(f, op{opUnpack, len(s.Targets)})
+	for _, n := range s.Targets {
+		f = g.store(f, n)
+	}
+	return f
+}
+
+// stores the value on the top of the stack to a variable or a field
+func (g *generator) store(f function, n *ast.Name) function {
+	if r, field, ok := splitField(n); ok {
+		f = g.expr(f, r)
+		return append(f, op{opSwap, nil}, op{opSetField, field})
+	}
+	if k, ok := g.slot(n); ok {
+		return append(f, op{opStoreSlot, k})
+	}
+	if len(n.Index) == 0 {
+		return append(f, op{opStore, strings.Repeat(".", n.Dots) + n.Name})
+	}
+	f = g.name(f, n)
+	return append(f, op{opSwap, nil}, op{opStoreStr, nil})
+}
+
+// if n is a field, returns the record and the name of the field
+func splitField(n *ast.Name) (*ast.Name, string, bool) {
+	if len(n.Fields) == 0 {
+		return nil, "", false
+	}
+	r := *n
+	r.Fields = n.Fields[:len(n.Fields)-1]
+	return &r, n.Fields[len(n.Fields)-1], true
+}
+
+// puts the name of a variable to the stack
+func (g *generator) name(f function, n *ast.Name) function {
+	f = append(f, op{opString, strings.Repeat(".", n.Dots) + n.Name})
+	for _, x := range n.Index {
+		f = append(f, op{opString, "["})
+		f = append(f, op{opSum, nil})
+		f = g.expr(f, x)
+		f = append(f, op{opSum, nil})
+		f = append(f, op{opString, "]"})
+		f = append(f, op{opSum, nil})
+	}
+	return f
+}
+
+func (g *generator) expr(f function, x ast.Expr) function {
+	switch x := x.(type) {
+	case *ast.Name:
+		if k, ok := g.slot(x); ok {
+			f = append(f, op{opLoadSlot, k})
+		} else {
+			f = g.name(f, x)
+			f = append(f, op{opLoadStr, nil})
+		}
+		for _, field := range x.Fields {
+			f = append(f, op{opField, field})
+		}
+	case *ast.RecordLit:
+		for _, field := range x.Fields {
+			f = append(f, op{opString, field.Name})
+			f = g.expr(f, field.Value)
+		}
+		f = append(f, op{opRecord, len(x.Fields)})
+	case *ast.BasicLit:
+		f = append(f, literal(x))
+	case *ast.Builtin:
+		f = append(f, op{builtinOp[x.Name], nil})
+	case *ast.BuiltinCall:
+		if x.Name == "defined" {
+			f = g.name(f, x.Args[0].(*ast.Name))
+		} else {
+			for _, a := range x.Args {
+				f = g.expr(f, a)
+			}
+		}
+		switch x.Name {
+		case "range":
+			f = append(f, op{opRange, len(x.Args)})
+		case "@open":
+			f = append(f, op{opOpen, len(x.Args)})
+		default:
+			f = append(f, op{builtinCallOp[x.Name], nil})
+		}
+	case *ast.FuncLit:
+		f = append(f, op{opFunction, g.function("", x, x.Body.Stmts)})
+	case *ast.UnaryExpr:
+		f = g.expr(f, x.X)
+		f = append(f, op{unaryOp[x.Op], nil})
+	case *ast.BinaryExpr:
+		f = g.expr(f, x.X)
+		f = g.expr(f, x.Y)
+		f = append(f, op{binaryOp[x.Op], nil})
+	case *ast.CondExpr:
+		f = g.expr(f, x.Cond)
+		then := g.expr(make(function, 0), x.X)
+		elseExpr := g.expr(make(function, 0), x.Y)
+		then = append(then, op{opJmp, len(elseExpr) + 1})
+		f = append(f, op{opJmpFalse, len(then) + 1})
+		f = append(f, then...)
+		f = append(f, elseExpr...)
+	case *ast.ParenExpr:
+		f = g.expr(f, x.X)
+	case *ast.CallExpr:
+		f = g.expr(f, x.Fun)
+		for _, a := range x.Args {
+			f = g.expr(f, a)
+		}
+		if g.lines {
+			name := strings.TrimLeft(x.Fun.Name, ".")
+			if len(x.Fun.Fields) > 0 {
+				name = x.Fun.Fields[len(x.Fun.Fields)-1]
+			}
+			f = append(f, op{opCallee, name})
+		}
+		f = append(f, op{opCall, len(x.Args)})
+	default:
+		panic("unknown expression")
+	}
+	return f
+}
+
+// literal values are checked by the parser
+func literal(x *ast.BasicLit) op {
+	switch x.Kind {
+	case ast.Int:
+		i, _ := intValue(x.Value)
+		return op{opInt, i}
+	case ast.Float:
+		fl, _ := floatValue(x.Value)
+		return op{opFloat, fl}
+	case ast.Bool:
+		b, _ := strconv.ParseBool(x.Value)
+		return op{opBool, b}
+	case ast.Nil:
+		return op{opNil, nil}
+	}
+	s, _ := strconv.Unquote(x.Value)
+	return op{opString, s}
+}
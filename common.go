@@ -1,15 +1,18 @@
 package yail
 
+import "fmt"
+
 // Lexeme.
 type lex struct {
 	typ lexType
 	val string
+	pos int    // byte offset in the source code
+	err string // description of lexError
 }
 
 type lexType int
 
 const (
-	lexemeBuffer            = 10
 	eof                     = 0
 	lexError        lexType = iota
 	lexLeftPar              // (
@@ -36,6 +39,28 @@ const (
 	lexLess                 // <
 	lexGeq                  // >=
 	lexLeq                  // <=
+	lexBitAnd               // &
+	lexBitOr                // |
+	lexBitXor               // ^
+	lexBitNot               // ~
+	lexShl                  // <<
+	lexShr                  // >>
+	lexQuestion             // ?
+	lexColon                // :
+	lexNil
+	lexTypeOf  // type
+	lexDefined // defined
+	lexToInt   // int
+	lexToFloat // float
+	lexToStr   // str
+	lexToBool  // bool
+	lexTry
+	lexCatch
+	lexFinally
+	lexThrow
+	lexFunc
+	lexRange // range
+	lexImport
 	lexIf
 	lexElse
 	lexFor
@@ -54,6 +79,12 @@ const (
 	lexPrint      // @print
 	lexPrintLn    // @println
 	lexRnd        // @rnd
+	lexOpen       // @open
+	lexRead       // @read
+	lexWrite      // @write
+	lexClose      // @close
+	lexReadLines  // @readLines
+	lexComment    // only if the lexer keeps comments
 	lexEof
 )
 
@@ -92,15 +123,89 @@ const (
 	opReadString
 	opReadLine
 	opReadChar
-	opPrint   // prints space separated values; param: number of values int
-	opPrintLn // prints space separated values, appends newline; param: number of values int
-	opRnd     // puts random int to the stack
-	opPop     // discard the top element of the stack
-	opReturn  // returns from the function
+	opPrint     // prints space separated values; param: number of values int
+	opPrintLn   // prints space separated values, appends newline; param: number of values int
+	opRnd       // puts random int to the stack
+	opPop       // discard the top element of the stack
+	opReturn    // returns from the function
+	opFrame     // allocates slots for local variables; param: *frame
+	opLoadSlot  // load constant from a local variable; param: slot int
+	opStoreSlot // store constant to a local variable; param: slot int
+	opBitAnd    // bitwise and of two integers
+	opBitOr     // bitwise or of two integers
+	opBitXor    // bitwise xor of two integers
+	opBitNot    // bitwise complement of an integer
+	opShl       // shifts an integer left
+	opShr       // shifts an integer right (arithmetic shift)
+	opNil       // constant nil
+	opTypeOf    // replaces a value by the name of its type
+	opDefined   // checks if a variable exists; variable name is a string on the stack
+	opToInt     // converts a value to int or nil
+	opToFloat   // converts a value to float or nil
+	opToStr     // converts a value to string
+	opToBool    // converts a value to bool or nil
+	opLine      // sets the source position for error messages; param: ast.Pos
+	opTry       // installs an error handler; param: diff of the handler int
+	opEndTry    // removes error handlers; param: number of handlers to keep int
+	opThrow     // throws the value on the top of the stack
+	opCallee    // names the function called by the next opCall for stack traces; param: name string
+	opName      // names the running function for stack traces; param: name string
+	opDeclare   // declares a function or a module visible to the called functions; param: name string
+	opArgs      // checks the number of arguments on the stack; param: arity
+	opJmpArg    // jumps if an argument is left on the stack; param: diff int
+	opRest      // stores the arguments left on the stack to a pseudo-array; param: name string
+	opPack      // replaces values by a tuple for returning them; param: number of values int
+	opUnpack    // replaces a tuple by its values, the first on the top; param: number of values int
+	opCallMulti // like opCall, but the function may return a tuple; param: number of arguments int
+	opSwap      // swaps two values on the top of the stack
+	opRange     // replaces integers by a range; param: number of them (1 to 3) int
+	opIter      // replaces a range or a string by an iterator
+	opNext      // pushes the next value of the iterator or pops it and jumps; param: diff int
+	opRecord    // replaces field names and values by a record; param: number of fields int
+	opField     // replaces a record by the value of its field; param: name string
+	opSetField  // sets a field of a record to a value, pops both; param: name string
+	opImport    // pushes a record of the top-level variables of a module; param: *module
+	opOpen      // opens a file: pops its name and optionally mode; param: number of arguments int
+	opFileRead  // replaces a file by its next line or nil
+	opFileWrite // writes a value to a file, pops both and pushes nil
+	opClose     // closes a file, replaces it by nil
+	opReadLines // replaces a file name by the file opened for reading until its end
+	numOps      // number of op types; must be the last one
 )
 
 type function []op
 
+// multiple values returned by a function
+type tuple []interface{}
+
+// number of arguments accepted by a function
+type arity struct {
+	min, max int // max is -1 if there is no limit
+}
+
+func (a arity) String() string {
+	switch {
+	case a.max < 0:
+		return fmt.Sprintf("at least %d %s", a.min, plural(a.min, "argument"))
+	case a.min == a.max:
+		return fmt.Sprintf("%d %s", a.min, plural(a.min, "argument"))
+	}
+	return fmt.Sprintf("%d to %d arguments", a.min, a.max)
+}
+
+// returns the form of a noun to follow n, e.g. "argument" or "arguments"
+func plural(n int, noun string) string {
+	if n == 1 {
+		return noun
+	}
+	return noun + "s"
+}
+
+// Program is a compiled YAIL program ready to be run or stored.
+type Program struct {
+	main function
+}
+
 type variable struct {
 }
 
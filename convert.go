@@ -0,0 +1,95 @@
+package yail
+
+// This file contains conversions between types done by the builtin functions
+// int, float, str and bool. A value which can not be converted, e.g. a string
+// which is not a number, is converted to nil.
+
+import (
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// converts to int, floats are truncated towards zero
+func toInt(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int64, *big.Int:
+		return v
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil
+		}
+		if v >= math.MinInt64 && v < math.MaxInt64 {
+			return int64(v)
+		}
+		i, _ := big.NewFloat(v).Int(nil)
+		return i
+	case string:
+		s := strings.TrimSpace(v)
+		if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return i
+		}
+		if strings.HasPrefix(s, "+") {
+			s = s[1:]
+		}
+		if i, ok := new(big.Int).SetString(s, 10); ok {
+			return normalize(i)
+		}
+	case bool:
+		if v {
+			return int64(1)
+		}
+		return int64(0)
+	}
+	return nil
+}
+
+func toFloat64(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int64, *big.Int, float64:
+		return toFloat(v)
+	case string:
+		if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+			return f
+		}
+	case bool:
+		if v {
+			return 1.0
+		}
+		return 0.0
+	}
+	return nil
+}
+
+func toStr(v interface{}) interface{} {
+	if s, ok := concatString(v); ok {
+		return s
+	}
+	switch v := v.(type) {
+	case bool:
+		return strconv.FormatBool(v)
+	case nil:
+		return "nil"
+	}
+	return typeName(v)
+}
+
+// converts to bool, numbers are true unless they are zero
+func toBool(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int64:
+		return v != 0
+	case *big.Int:
+		return v.Sign() != 0
+	case float64:
+		return v != 0
+	case string:
+		if b, err := strconv.ParseBool(strings.TrimSpace(v)); err == nil {
+			return b
+		}
+	case bool:
+		return v
+	}
+	return nil
+}
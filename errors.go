@@ -0,0 +1,123 @@
+package yail
+
+// This file contains runtime errors and their handling by try statements.
+
+import "github.com/mabu/yail/ast"
+
+// A RuntimeError is an error which stopped a program: a value passed to
+// throw or an error of the interpreter, e.g. division by zero.
+type RuntimeError struct {
+	Pos   ast.Pos // position of the statement which caused the error
+	Msg   string
+	Trace []Frame // the function which failed first, then its callers
+
+	value  interface{}  // value passed to throw
+	thrown bool         // thrown by throw, not by the interpreter
+	in     *interpreter // which is handling the error, nil when it leaves
+	raised ast.Pos      // where the error was raised in it
+}
+
+// A Frame is a function call which was active when a runtime error occurred.
+type Frame struct {
+	Func string  // name of the function: "main" for the program, file name for a module, empty if unknown
+	Pos  ast.Pos // position of the statement being executed
+}
+
+func (f Frame) String() string {
+	name := f.Func
+	if name == "" {
+		name = "anonymous function"
+	}
+	if !f.Pos.IsValid() {
+		return name
+	}
+	return name + " at " + f.Pos.String()
+}
+
+// Returns the message followed by the stack trace, one frame per line, if
+// the error happened inside a function.
+func (e *RuntimeError) Error() string {
+	s := "Runtime error: " + e.text() + "."
+	if len(e.Trace) > 1 {
+		for _, f := range e.Trace {
+			s += "\n\t" + f.String()
+		}
+	}
+	return s
+}
+
+// returns the position and the message
+func (e *RuntimeError) text() string {
+	if !e.Pos.IsValid() {
+		return e.Msg
+	}
+	return e.Pos.String() + ": " + e.Msg
+}
+
+// returns a field of an error received by catch: msg, line or col
+func (e *RuntimeError) field(name string) (interface{}, bool) {
+	switch name {
+	case "msg":
+		return e.Msg, true
+	case "line":
+		return int64(e.Pos.Line), true
+	case "col":
+		return int64(e.Pos.Col), true
+	}
+	return nil, false
+}
+
+// returns the value received by catch: the thrown value or the error itself
+func (e *RuntimeError) catchValue() interface{} {
+	if e.thrown {
+		return e.value
+	}
+	return e
+}
+
+func runtimeErr(str string) {
+	panic(&RuntimeError{Msg: str})
+}
+
+// throws a value; errors received by catch are thrown again unchanged
+func throw(val interface{}) {
+	if e, ok := val.(*RuntimeError); ok {
+		panic(e)
+	}
+	panic(&RuntimeError{Msg: toStr(val).(string), value: val, thrown: true})
+}
+
+// an error handler installed by opTry
+type handler struct {
+	ic    int // position of the handler
+	stack int // size of the stack when the handler was installed
+}
+
+// handles a panic: returns position of the error handler to continue with
+// or panics again if there is no handler
+func (i *interpreter) catch(r interface{}) int {
+	e, ok := r.(*RuntimeError)
+	if !ok {
+		panic(r)
+	}
+	if !e.Pos.IsValid() {
+		e.Pos = i.pos
+	}
+	if e.in != i { // kept through catch and finally blocks
+		e.in, e.raised = i, i.pos
+	}
+	if len(i.handlers) == 0 {
+		name := i.name
+		if i.parent == nil && name == "" {
+			name = "main"
+		}
+		e.Trace = append(e.Trace, Frame{name, e.raised})
+		e.in = nil
+		panic(e)
+	}
+	h := i.handlers[len(i.handlers)-1]
+	i.handlers = i.handlers[:len(i.handlers)-1]
+	i.stack = i.stack[:h.stack]
+	i.push(e.catchValue())
+	return h.ic
+}
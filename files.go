@@ -0,0 +1,169 @@
+package yail
+
+// This file contains file access of programs with the builtins @open, @read,
+// @write, @close and @readLines. Programs can use only the files in a
+// directory allowed by the host, paths leading out of it (e.g. by .. or
+// symbolic links) are rejected.
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Files allows a program to use the files in a directory. File access needs
+// Go 1.24 or later: programs built with older versions fail to start.
+type Files struct {
+	Root     string // directory with the files; names used by programs are relative to it
+	ReadOnly bool   // files can not be created or written
+}
+
+// a directory which rejects paths leading out of it, an *os.Root
+type fileRoot interface {
+	OpenFile(name string, flag int, perm os.FileMode) (*os.File, error)
+	Close() error
+}
+
+// files of a running program, shared by all interpreters
+type fileSystem struct {
+	root     fileRoot
+	readOnly bool
+	open     map[*file]bool // closed when the program ends
+}
+
+func openFiles(files *Files) (*fileSystem, error) {
+	root, err := openRoot(files.Root)
+	if err != nil {
+		return nil, err
+	}
+	return &fileSystem{root, files.ReadOnly, make(map[*file]bool)}, nil
+}
+
+// closes the files left open by the program
+func (fs *fileSystem) close() {
+	for f := range fs.open {
+		f.close()
+	}
+	fs.root.Close()
+}
+
+// A file opened by a program.
+type file struct {
+	name       string
+	fs         *fileSystem
+	f          *os.File
+	r          *bufio.Reader // nil unless opened for reading
+	w          *bufio.Writer // nil unless opened for writing
+	closeAtEOF bool          // opened by @readLines
+	closed     bool
+}
+
+func (f *file) String() string {
+	return "file(" + strconv.Quote(f.name) + ")"
+}
+
+var fileModes = map[string]int{
+	"r": os.O_RDONLY,
+	"w": os.O_WRONLY | os.O_CREATE | os.O_TRUNC,
+	"a": os.O_WRONLY | os.O_CREATE | os.O_APPEND,
+}
+
+// opens a file for reading (mode "r"), writing ("w") or appending ("a")
+func (i *interpreter) open(name, mode, err string) *file {
+	if i.files == nil {
+		runtimeErr(err + ": file access is not allowed")
+	}
+	flag, ok := fileModes[mode]
+	if !ok {
+		runtimeErr(err + ": unknown mode " + strconv.Quote(mode))
+	}
+	if mode != "r" && i.files.readOnly {
+		runtimeErr(err + ": can not write " + name + ": files are read-only")
+	}
+	osf, e := i.files.root.OpenFile(filepath.FromSlash(name), flag, 0644)
+	if e != nil {
+		runtimeErr(err + ": " + e.Error())
+	}
+	f := &file{name: name, fs: i.files, f: osf}
+	if mode == "r" {
+		f.r = bufio.NewReader(osf)
+	} else {
+		f.w = bufio.NewWriter(osf)
+	}
+	i.files.open[f] = true
+	return f
+}
+
+func getFile(v interface{}, err string) *file {
+	f, ok := v.(*file)
+	if !ok {
+		runtimeErr(err + ": " + typeName(v) + " is not a file")
+	}
+	if f.closed {
+		runtimeErr(err + ": file " + f.name + " is closed")
+	}
+	return f
+}
+
+// returns the next line without the line break or false at the end of the
+// file
+func (f *file) readLine(err string) (string, bool) {
+	if f.r == nil {
+		runtimeErr(err + ": file " + f.name + " is not open for reading")
+	}
+	line, e := f.r.ReadString('\n')
+	if e == io.EOF && line == "" {
+		if f.closeAtEOF {
+			f.close()
+		}
+		return "", false
+	}
+	if e != nil && e != io.EOF {
+		runtimeErr(err + ": " + e.Error())
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), true
+}
+
+// writes a value like @print
+func (f *file) write(val interface{}, err string) {
+	if f.w == nil {
+		runtimeErr(err + ": file " + f.name + " is not open for writing")
+	}
+	if _, e := fmt.Fprint(f.w, printable([]interface{}{val})...); e != nil {
+		runtimeErr(err + ": " + e.Error())
+	}
+}
+
+func (f *file) close() error {
+	if f.closed {
+		return errors.New("file " + f.name + " is already closed")
+	}
+	f.closed = true
+	delete(f.fs.open, f)
+	var err error
+	if f.w != nil {
+		err = f.w.Flush()
+	}
+	if e := f.f.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// iterates over the remaining lines of a file
+type lineIterator struct {
+	f *file
+}
+
+func (it *lineIterator) next() (interface{}, bool) {
+	if it.f.closed {
+		return nil, false
+	}
+	line, ok := it.f.readLine("opNext failed")
+	return line, ok
+}
@@ -0,0 +1,11 @@
+//go:build !go1.24
+// +build !go1.24
+
+package yail
+
+import "errors"
+
+// os.Root is needed to keep paths inside the directory
+func openRoot(dir string) (fileRoot, error) {
+	return nil, errors.New("file access needs Go 1.24 or later")
+}
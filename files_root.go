@@ -0,0 +1,14 @@
+//go:build go1.24
+// +build go1.24
+
+package yail
+
+import "os"
+
+func openRoot(dir string) (fileRoot, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	return root, nil
+}
@@ -0,0 +1,84 @@
+//go:build go1.24
+// +build go1.24
+
+package yail
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "app.log"), []byte("ok\r\nfail 1\n\nfail 2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	prog, err := Compile(`fails = 0
+for line in @readLines("app.log") {
+	if line != "ok" && line != "" {
+		fails = fails + 1
+	}
+}
+out = @open("report.txt", "w")
+@write(out, "fails: ")
+@write(out, fails)
+@close(out)
+out = @open("report.txt", "a")
+@write(out, "\n")
+f = @open("app.log")
+@read(f)
+@println(f, type(f), f == f, @read(f))
+for line in f {
+	@println(line)
+}
+@println(@read(f))`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := prog.RunWithFiles(strings.NewReader(""), &out, &Files{Root: dir}); err != nil {
+		t.Fatal(err)
+	}
+	if expect := "file(\"app.log\") file true fail 1\n\nfail 2\nnil\n"; out.String() != expect {
+		t.Errorf("Got output %q, expected %q.", out.String(), expect)
+	}
+	if report, err := os.ReadFile(filepath.Join(dir, "report.txt")); err != nil || string(report) != "fails: 2\n" {
+		t.Errorf("Got report %q and error %v.", report, err)
+	}
+}
+
+func TestFilesError(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for source, msg := range map[string]string{
+		`f = @open("../a.txt")`:                      "Runtime error: 1:1: opOpen failed: openat ../a.txt: path escapes from parent.",
+		`f = @open("a.txt", "w")`:                    "Runtime error: 1:1: opOpen failed: can not write a.txt: files are read-only.",
+		`f = @open("a.txt", "x")`:                    `Runtime error: 1:1: opOpen failed: unknown mode "x".`,
+		`@write(@open("a.txt"), 1)`:                  "Runtime error: 1:1: opFileWrite failed: file a.txt is not open for writing.",
+		"f = @open(\"a.txt\")\n@close(f)\n@close(f)": "Runtime error: 3:1: opClose failed: file a.txt is closed.",
+		`@read("a.txt")`:                             "Runtime error: 1:1: opFileRead failed: string is not a file.",
+	} {
+		prog, err := Compile(source, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := prog.RunWithFiles(strings.NewReader(""), new(bytes.Buffer), &Files{Root: dir, ReadOnly: true}); err == nil || err.Error() != msg {
+			t.Errorf("Running %q got error %v, expected %q.", source, err, msg)
+		}
+	}
+	prog, err := Compile(`f = @open("a.txt")`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := prog.Run(strings.NewReader(""), new(bytes.Buffer)); err == nil || err.Error() != "Runtime error: 1:1: opOpen failed: file access is not allowed." {
+		t.Errorf("Got error %v.", err)
+	}
+	if err := prog.RunWithFiles(strings.NewReader(""), new(bytes.Buffer), &Files{Root: filepath.Join(dir, "missing")}); err == nil {
+		t.Error("Expected an error for a missing root directory.")
+	}
+}
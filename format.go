@@ -0,0 +1,337 @@
+package yail
+
+// This file contains a source code formatter. It prints a syntax tree in the
+// canonical form: one statement per line, tab indentation and single spaces
+// around binary operators. Comments and single blank lines between statements
+// are preserved.
+
+import (
+	"bytes"
+	"math"
+	"strings"
+
+	"github.com/mabu/yail/ast"
+)
+
+// Formats YAIL source code.
+func Format(source string) (string, error) {
+	file, err := ParseFile(source)
+	if err != nil {
+		return "", err
+	}
+	return FormatFile(file), nil
+}
+
+// Prints a syntax tree as formatted source code.
+func FormatFile(file *ast.File) string {
+	p := printer{comments: file.Comments}
+	p.stmts(file.Stmts)
+	p.flushComments(ast.Pos{Line: math.MaxInt32})
+	if p.buf.Len() > 0 {
+		p.buf.WriteByte('\n')
+	}
+	return p.buf.String()
+}
+
+type printer struct {
+	buf      bytes.Buffer
+	indent   int
+	comments []*ast.Comment // not printed yet
+	line     int            // source line of the last printed token
+	first    bool           // nothing printed yet in the current block
+}
+
+func (p *printer) stmts(stmts []ast.Stmt) {
+	for _, s := range stmts {
+		p.flushComments(s.Pos())
+		p.beginLine(s.Pos().Line)
+		p.stmt(s)
+	}
+}
+
+// starts a new output line, keeping a blank line if there was one in source
+func (p *printer) beginLine(line int) {
+	if p.buf.Len() > 0 {
+		p.buf.WriteByte('\n')
+		if !p.first && line > p.line+1 {
+			p.buf.WriteByte('\n')
+		}
+	}
+	for i := 0; i < p.indent; i++ {
+		p.buf.WriteByte('\t')
+	}
+	p.first = false
+	if line > p.line {
+		p.line = line
+	}
+}
+
+// prints comments located before pos
+func (p *printer) flushComments(pos ast.Pos) {
+	for len(p.comments) > 0 && p.comments[0].Slash.Before(pos) {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+		if c.Slash.Line == p.line && p.buf.Len() > 0 {
+			p.buf.WriteByte(' ')
+		} else {
+			p.beginLine(c.Slash.Line)
+		}
+		p.buf.WriteString(c.Text)
+		p.line = c.Slash.Line + strings.Count(c.Text, "\n")
+	}
+}
+
+// prints block comments located before pos inside a line: before an
+// expression they are followed by a space, before a closing bracket preceded
+// by one. Line comments are left to the next statement.
+func (p *printer) inlineComments(pos ast.Pos, closing bool) {
+	for len(p.comments) > 0 && p.comments[0].Slash.Before(pos) && strings.HasPrefix(p.comments[0].Text, "/*") {
+		c := p.comments[0]
+		p.comments = p.comments[1:]
+		if closing {
+			p.buf.WriteByte(' ')
+		}
+		p.buf.WriteString(c.Text)
+		if !closing {
+			p.buf.WriteByte(' ')
+		}
+		if end := c.Slash.Line + strings.Count(c.Text, "\n"); end > p.line {
+			p.line = end
+		}
+	}
+}
+
+func (p *printer) block(b *ast.Block) {
+	p.buf.WriteByte('{')
+	p.line = b.Lbrace.Line
+	p.indent++
+	p.first = true
+	p.stmts(b.Stmts)
+	n := len(p.comments)
+	p.flushComments(b.Rbrace)
+	p.indent--
+	if !p.first || len(p.comments) < n { // a comment may end the line
+		p.first = true // no blank line before }
+		p.beginLine(b.Rbrace.Line)
+	}
+	p.buf.WriteByte('}')
+	p.first = false
+	p.line = b.Rbrace.Line
+}
+
+func (p *printer) stmt(s ast.Stmt) {
+	switch s := s.(type) {
+	case *ast.AssignStmt:
+		p.assign(s)
+	case *ast.CallStmt:
+		p.expr(s.Call)
+	case *ast.BuiltinStmt:
+		p.expr(s.Call)
+	case *ast.PrintStmt:
+		if s.NewLine {
+			p.buf.WriteString("@println")
+		} else {
+			p.buf.WriteString("@print")
+		}
+		p.args(s.Args, s.Rparen)
+	case *ast.MultiAssignStmt:
+		for i, n := range s.Targets {
+			if i > 0 {
+				p.buf.WriteString(", ")
+			}
+			p.expr(n)
+		}
+		p.buf.WriteString(" = ")
+		p.list(s.Values)
+	case *ast.ReturnStmt:
+		p.buf.WriteString("return")
+		if len(s.Values) > 0 {
+			p.buf.WriteByte(' ')
+			p.list(s.Values)
+		}
+	case *ast.IfStmt:
+		p.buf.WriteString("if ")
+		p.expr(s.Cond)
+		p.buf.WriteByte(' ')
+		p.block(s.Body)
+		if s.Else != nil {
+			p.buf.WriteString(" else ")
+			p.block(s.Else)
+		}
+	case *ast.ForStmt:
+		p.buf.WriteString("for ")
+		if s.Init != nil {
+			p.assign(s.Init)
+		}
+		p.buf.WriteString("; ")
+		p.expr(s.Cond)
+		p.buf.WriteString("; ")
+		if s.Post != nil {
+			p.assign(s.Post)
+			p.buf.WriteByte(' ')
+		}
+		p.block(s.Body)
+	case *ast.ForInStmt:
+		p.buf.WriteString("for ")
+		p.expr(s.Var)
+		p.buf.WriteString(" in ")
+		p.expr(s.X)
+		p.buf.WriteByte(' ')
+		p.block(s.Body)
+	case *ast.WhileStmt:
+		p.buf.WriteString("while ")
+		p.expr(s.Cond)
+		p.buf.WriteByte(' ')
+		p.block(s.Body)
+	case *ast.TryStmt:
+		p.buf.WriteString("try ")
+		p.block(s.Body)
+		if s.Catch != nil {
+			p.buf.WriteString(" catch ")
+			if s.Param != nil {
+				p.buf.WriteString("(" + s.Param.Name + ") ")
+			}
+			p.block(s.Catch)
+		}
+		if s.Finally != nil {
+			p.buf.WriteString(" finally ")
+			p.block(s.Finally)
+		}
+	case *ast.FuncDecl:
+		p.buf.WriteString("func " + s.Name.Name)
+		p.expr(s.Fun)
+	case *ast.ImportStmt:
+		p.buf.WriteString("import " + s.Path.Value + " as " + s.Name.Name)
+	case *ast.ThrowStmt:
+		p.buf.WriteString("throw(")
+		p.expr(s.Value)
+		p.buf.WriteByte(')')
+	}
+}
+
+// prints comma separated expressions
+func (p *printer) list(xs []ast.Expr) {
+	for i, x := range xs {
+		if i > 0 {
+			p.buf.WriteString(", ")
+		}
+		p.expr(x)
+	}
+}
+
+func (p *printer) assign(s *ast.AssignStmt) {
+	p.expr(s.Target)
+	p.buf.WriteString(" = ")
+	p.expr(s.Value)
+}
+
+func (p *printer) args(args []ast.Expr, rparen ast.Pos) {
+	p.buf.WriteByte('(')
+	p.list(args)
+	p.inlineComments(rparen, true)
+	p.buf.WriteByte(')')
+}
+
+func (p *printer) expr(x ast.Expr) {
+	p.inlineComments(x.Pos(), false)
+	switch x := x.(type) {
+	case *ast.Name:
+		p.buf.WriteString(strings.Repeat(".", x.Dots))
+		p.buf.WriteString(x.Name)
+		for _, i := range x.Index {
+			p.buf.WriteByte('[')
+			p.expr(i)
+			p.buf.WriteByte(']')
+		}
+		for _, field := range x.Fields {
+			p.buf.WriteString("." + field)
+		}
+	case *ast.RecordLit:
+		if x.Rbrace.Line > x.Lbrace.Line && len(p.comments) > 0 && p.comments[0].Slash.Before(x.Rbrace) {
+			p.record(x)
+			break
+		}
+		p.buf.WriteByte('{')
+		for i, field := range x.Fields {
+			if i > 0 {
+				p.buf.WriteString(", ")
+			}
+			p.inlineComments(field.NamePos, false)
+			p.buf.WriteString(field.Name + ": ")
+			p.expr(field.Value)
+		}
+		p.inlineComments(x.Rbrace, true)
+		p.buf.WriteByte('}')
+		if x.Rbrace.Line > p.line { // printed on one line
+			p.line = x.Rbrace.Line
+		}
+	case *ast.BasicLit:
+		p.buf.WriteString(x.Value)
+	case *ast.Builtin:
+		p.buf.WriteString(x.Name)
+	case *ast.BuiltinCall:
+		p.buf.WriteString(x.Name)
+		p.args(x.Args, x.Rparen)
+	case *ast.FuncLit:
+		p.buf.WriteByte('(')
+		for i, n := range x.Params {
+			if i > 0 {
+				p.buf.WriteString(", ")
+			}
+			p.inlineComments(n.NamePos, false)
+			p.buf.WriteString(n.Name)
+			if x.Defaults != nil && x.Defaults[i] != nil {
+				p.buf.WriteString(" = ")
+				p.expr(x.Defaults[i])
+			}
+		}
+		if x.Ellipsis.IsValid() {
+			p.buf.WriteString("...")
+		}
+		p.buf.WriteString(") ")
+		p.block(x.Body)
+	case *ast.UnaryExpr:
+		p.buf.WriteString(x.Op)
+		p.expr(x.X)
+	case *ast.BinaryExpr:
+		p.expr(x.X)
+		p.buf.WriteString(" " + x.Op + " ")
+		p.expr(x.Y)
+	case *ast.CondExpr:
+		p.expr(x.Cond)
+		p.buf.WriteString(" ? ")
+		p.expr(x.X)
+		p.buf.WriteString(" : ")
+		p.expr(x.Y)
+	case *ast.ParenExpr:
+		p.buf.WriteByte('(')
+		p.expr(x.X)
+		p.buf.WriteByte(')')
+	case *ast.CallExpr:
+		p.expr(x.Fun)
+		p.args(x.Args, x.Rparen)
+	}
+}
+
+// prints a record literal with comments inside one field per line, like in
+// source
+func (p *printer) record(x *ast.RecordLit) {
+	p.buf.WriteByte('{')
+	p.line = x.Lbrace.Line
+	p.indent++
+	p.first = true
+	for _, field := range x.Fields {
+		p.flushComments(field.NamePos)
+		p.beginLine(field.NamePos.Line)
+		p.buf.WriteString(field.Name + ": ")
+		p.expr(field.Value)
+		p.buf.WriteByte(',')
+	}
+	p.flushComments(x.Rbrace)
+	p.indent--
+	p.first = true // no blank line before }
+	p.beginLine(x.Rbrace.Line)
+	p.buf.WriteByte('}')
+	p.first = false
+	p.line = x.Rbrace.Line
+}
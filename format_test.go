@@ -0,0 +1,127 @@
+package yail
+
+import "testing"
+
+func TestFormat(t *testing.T) {
+	runFormatTest(t, `// Factorial.
+fun=(x){ /* recursive */
+  fun=.fun;if x==0{return 1}else{
+return x*fun(x-1) // tail
+}
+}
+
+
+@print( fun(5) ,"!")
+for i=0;i<3;i=i+1{@println(-i, !(b[i] || c))}
+while @int>0{}`, `// Factorial.
+fun = (x) { /* recursive */
+	fun = .fun
+	if x == 0 {
+		return 1
+	} else {
+		return x * fun(x - 1) // tail
+	}
+}
+
+@print(fun(5), "!")
+for i = 0; i < 3; i = i + 1 {
+	@println(-i, !(b[i] || c))
+}
+while @int > 0 {}
+`)
+}
+
+func TestFormatComments(t *testing.T) {
+	runFormatTest(t, `
+/* header
+   comment */
+
+a = 1
+
+// about b
+b = 2; c = 3
+if a {
+	// nothing
+}
+// end`, `/* header
+   comment */
+
+a = 1
+
+// about b
+b = 2
+c = 3
+if a {
+	// nothing
+}
+// end
+`)
+	runFormatTest(t, "if a { // nothing\n}\nf = () { /* a */ }", `if a { // nothing
+}
+f = () { /* a */
+}
+`)
+	runFormatTest(t, `p = {x: 1, // one
+
+  y: {a: 2,/* two */b: 3} /* three */
+	// four
+}
+@println(a,/* c */b /* d */)
+x = 1 + /* e */ 2`, `p = {
+	x: 1, // one
+
+	y: {a: 2, /* two */ b: 3}, /* three */
+	// four
+}
+@println(a, /* c */ b /* d */)
+x = 1 + /* e */ 2
+`)
+	if _, err := Format("a = 1\n/*"); err == nil {
+		t.Error("Formatting an unterminated comment did not fail.")
+	}
+}
+
+func TestFormatExamples(t *testing.T) {
+	for _, source := range []string{"", "a = 1\n", "a = b > 0 ? (c ? 1 : 2) : -1\n", `try {
+	throw("x")
+} catch (e) {
+	@println(e)
+} finally {}
+try {} catch {}
+func f(a, b = 1, c...) {
+	return a + b, c
+}
+x, .y[0] = f(1)
+import "util.yail" as util
+for c in range(0, 10, 2) {}
+for line in @readLines("a.log") {
+	@write(out, line)
+}
+@close(out)
+p = {name: "x", pos: {x: 1, y: 2}}
+p.pos.x = {}
+`, `f = () {
+	return
+}
+`} {
+		runFormatTest(t, source, source)
+	}
+}
+
+func runFormatTest(t *testing.T, source, expect string) {
+	got, err := Format(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != expect {
+		t.Errorf("Got:\n%s\nExpected:\n%s", got, expect)
+	}
+	again, err := Format(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != got {
+		t.Errorf("Formatting is not idempotent, got:\n%s", again)
+	}
+	testFun(t, parse(got), parse(source))
+}
@@ -6,36 +6,120 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"math"
+	"math/big"
 	"math/rand"
 	"os"
 	"strconv"
 	"time"
+
+	"github.com/mabu/yail/ast"
 )
 
 type interpreter struct {
-	stdin  io.Reader
-	stdout io.Writer
-	f      function
-	vars   map[string]interface{}
-	stack  []interface{}
-	parent *interpreter
+	stdin    io.Reader
+	stdout   io.Writer
+	f        function
+	vars     map[string]interface{} // variables which do not have slots
+	stack    []interface{}
+	parent   *interpreter // the caller or the module of a function called from outside
+	frame    *frame
+	slots    []interface{}
+	ops      *uint64 // number of executed ops, shared with children
+	pos      ast.Pos // of the current statement
+	handlers []handler
+	name     string                 // of the function, empty if unknown
+	callee   string                 // name of the function called by the next opCall or opCallMulti
+	funcs    map[string]interface{} // declared functions
+	imports  map[*module]*record    // shared with children
+	files    *fileSystem            // nil if the program can not use files
 }
 
+// value of a slot which was not assigned yet
+type undefinedValue struct{}
+
 // Interprets a program given its source code.
 // Use r for standard intput and w for standard output operations.
 func Interpret(code string, r io.Reader, w io.Writer) {
+	p, err := Compile(code, nil)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := p.Run(r, w); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// Runs a compiled program.
+// Use r for standard intput and w for standard output operations.
+// Returns a *RuntimeError if the program fails.
+func (p *Program) Run(r io.Reader, w io.Writer) error {
+	_, err := p.RunCount(r, w)
+	return err
+}
+
+// Runs a compiled program like Run and returns the number of executed ops.
+func (p *Program) RunCount(r io.Reader, w io.Writer) (ops uint64, err error) {
+	return p.run(r, w, nil)
+}
+
+// Runs a compiled program like Run. The program can use the files allowed by
+// files, none if it is nil. Files left open by the program are closed when it
+// ends.
+func (p *Program) RunWithFiles(r io.Reader, w io.Writer, files *Files) error {
+	_, err := p.run(r, w, files)
+	return err
+}
+
+func (p *Program) run(r io.Reader, w io.Writer, files *Files) (ops uint64, err error) {
 	rand.Seed(time.Now().UTC().UnixNano())
-	i := newInterpreter(parse(code), r, w)
+	i := newInterpreter(p.main, r, w)
+	if files != nil {
+		if i.files, err = openFiles(files); err != nil {
+			return 0, err
+		}
+		defer i.files.close()
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(*RuntimeError)
+			if !ok {
+				panic(r)
+			}
+			ops, err = *i.ops, e
+		}
+	}()
 	i.run()
+	return *i.ops, nil
 }
 
 func newInterpreter(f function, input io.Reader, output io.Writer) *interpreter {
-	return &interpreter{input, output, f, make(map[string]interface{}), make([]interface{}, 0), nil}
+	return &interpreter{stdin: input, stdout: output, f: f, stack: make([]interface{}, 0), ops: new(uint64), imports: make(map[*module]*record)}
 }
 
 func (i *interpreter) run() interface{} {
-	for ic := 0; ic < len(i.f); {
+	for ic := 0; ; {
+		ret, next, done := i.exec(ic)
+		if done {
+			return ret
+		}
+		ic = next
+	}
+}
+
+// executes ops starting from ic until the function returns (done is true) or
+// an error is caught by a handler where the execution continues
+func (i *interpreter) exec(ic int) (ret interface{}, next int, done bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			next = i.catch(r)
+		}
+	}()
+	for ic < len(i.f) {
 		op := (i.f)[ic]
+		*i.ops++
 		switch op.typ {
 		case opInt:
 			fallthrough
@@ -47,21 +131,41 @@ func (i *interpreter) run() interface{} {
 			fallthrough
 		case opFunction:
 			i.push(op.param)
-		case opCall:
+		case opNil:
+			i.push(nil)
+		case opTypeOf:
+			i.push(typeName(i.pop()))
+		case opToInt:
+			i.push(toInt(i.pop()))
+		case opToFloat:
+			i.push(toFloat64(i.pop()))
+		case opToStr:
+			i.push(toStr(i.pop()))
+		case opToBool:
+			i.push(toBool(i.pop()))
+		case opDefined:
+			i.push(i.defined(getString(i.pop(), "opDefined failed: variable name is not string")))
+		case opCall, opCallMulti:
 			args := getInt(op.param, "opCall failed: number of arguments is not int")
-			child := newInterpreter(nil, i.stdin, i.stdout)
-			child.parent = i
+			child := &interpreter{stdin: i.stdin, stdout: i.stdout, stack: make([]interface{}, 0), parent: i, ops: i.ops, imports: i.imports, files: i.files}
 			for args > 0 { // order reversal is intended
 				child.push(i.pop())
 				args--
 			}
-			f, ok := i.pop().(function)
-			if !ok {
+			switch f := i.pop().(type) {
+			case function:
+				child.f = f
+			case *moduleFunction: // its variables with dots are in the module
+				child.f, child.parent = f.f, f.module
+			default:
 				runtimeErr("opCall failed: function not found")
-				return nil
 			}
-			child.f = f
-			i.push(child.run())
+			child.name, i.callee = i.callee, ""
+			ret := child.run()
+			if t, ok := ret.(tuple); ok && op.typ != opCallMulti {
+				runtimeErr(fmt.Sprintf("opCall failed: %d values returned where one is expected", len(t)))
+			}
+			i.push(ret)
 		case opJmp:
 			ic += getInt(op.param, "opJmp failed") - 1
 		case opJmpFalse:
@@ -71,7 +175,7 @@ func (i *interpreter) run() interface{} {
 		case opLoad:
 			name0 := getString(op.param, "opLoad failed: non-string param")
 			interpr, name := trimDots(i, name0)
-			val, ok := interpr.vars[name]
+			val, ok := interpr.load(name)
 			if !ok {
 				runtimeErr("opLoad failed: variable " + name0 + " is undefined")
 			}
@@ -79,11 +183,11 @@ func (i *interpreter) run() interface{} {
 		case opStore:
 			name0 := getString(op.param, "opStore failed: non-string param")
 			interpr, name := trimDots(i, name0)
-			interpr.vars[name] = i.pop()
+			interpr.store(name, i.pop())
 		case opLoadStr:
 			name0 := getString(i.pop(), "opLoadStr failed: non-string name")
 			interpr, name := trimDots(i, name0)
-			val, ok := interpr.vars[name]
+			val, ok := interpr.load(name)
 			if !ok {
 				runtimeErr("opLoadStr failed: variable " + name0 + " is undefined")
 			}
@@ -92,19 +196,45 @@ func (i *interpreter) run() interface{} {
 			val := i.pop()
 			name0 := getString(i.pop(), "opStoreStr failed: non-string name")
 			interpr, name := trimDots(i, name0)
-			interpr.vars[name] = val
-		case opOr:
-			i.push(i.popBool("opOr failed") || i.popBool("opOr failed"))
+			interpr.store(name, val)
+		case opFrame:
+			i.frame = op.param.(*frame)
+			i.slots = make([]interface{}, len(i.frame.names))
+			for k := range i.slots {
+				i.slots[k] = undefinedValue{}
+			}
+		case opLoadSlot:
+			k := getInt(op.param, "opLoadSlot failed: non-int param")
+			val := i.slots[k]
+			if val == (undefinedValue{}) {
+				var ok bool
+				if val, ok = i.declared(i.frame.names[k]); !ok {
+					runtimeErr("opLoadSlot failed: variable " + i.frame.names[k] + " is undefined")
+				}
+			}
+			i.push(val)
+		case opStoreSlot:
+			i.slots[getInt(op.param, "opStoreSlot failed: non-int param")] = i.pop()
+		case opOr: // both operands are popped, unlike with ||
+			b, a := i.popBool("opOr failed"), i.popBool("opOr failed")
+			i.push(a || b)
 		case opAnd:
-			i.push(i.popBool("opAnd failed") && i.popBool("opAnd failed"))
+			b, a := i.popBool("opAnd failed"), i.popBool("opAnd failed")
+			i.push(a && b)
 		case opNot:
 			i.push(!i.popBool("opNot failed"))
 		case opEq:
+			if i.refEq(true) {
+				break
+			}
 			i.cmpOp("opEq", func(a, b int64) bool { return a == b },
 				func(a, b float64) bool { return a == b },
 				func(a, b bool) bool { return a == b },
 				func(a, b string) bool { return a == b })
 		case opNeq:
+			if i.refEq(false) {
+				break
+			}
 			i.cmpOp("opNeq", func(a, b int64) bool { return a != b },
 				func(a, b float64) bool { return a != b },
 				func(a, b bool) bool { return a != b },
@@ -130,58 +260,76 @@ func (i *interpreter) run() interface{} {
 				func(a, b bool) bool { runtimeErr("opGeq not defined on bool"); return false },
 				func(a, b string) bool { return a >= b })
 		case opSum:
-			switch s1 := i.pop().(type) {
-			case int64:
-				switch s2 := i.pop().(type) {
-				case int64:
-					i.push(s2 + s1)
-				case float64:
-					i.push(s2 + float64(s1))
-				case string:
-					i.push(s2 + itoa(s1))
-				default:
-					runtimeErr("opSum failed: could not add int and unknown")
-				}
-			case float64:
-				switch s2 := i.pop().(type) {
-				case int64:
-					i.push(float64(s2) + s1)
-				case float64:
-					i.push(s2 + s1)
-				case string:
-					i.push(s2 + ftoa(s1))
-				default:
-					runtimeErr("opSum failed: could not add float and unknown")
-				}
-			case string:
-				switch s2 := i.pop().(type) {
-				case int64:
-					i.push(itoa(s2) + s1)
-				case float64:
-					i.push(ftoa(s2) + s1)
-				case string:
-					i.push(s2 + s1)
-				default:
-					runtimeErr("opSum failed: could not add string and unknown")
-				}
+			s1, s2 := i.pop(), i.pop()
+			str1, ok1 := concatString(s1)
+			str2, ok2 := concatString(s2)
+			_, isStr1 := s1.(string)
+			_, isStr2 := s2.(string)
+			switch {
+			case (isStr1 || isStr2) && ok1 && ok2:
+				i.push(str2 + str1)
+			case isNumber(s1) && isNumber(s2):
+				i.push(arith("opSum", s2, s1, addInt, (*big.Int).Add,
+					func(a, b float64) float64 { return a + b }))
 			default:
-				runtimeErr("opSum failed: unknown type")
+				runtimeErr("opSum failed: could not add " + typeName(s2) + " and " + typeName(s1))
 			}
 		case opSub:
-			i.numberOp("opSub", func(a, b int64) int64 { return a - b },
+			i.numberOp("opSub", subInt, (*big.Int).Sub,
 				func(a, b float64) float64 { return a - b })
 		case opNeg:
-			i.push(-1)
+			i.push(int64(-1))
 			fallthrough
 		case opMul:
-			i.numberOp("opMul", func(a, b int64) int64 { return a * b },
+			i.numberOp("opMul", mulInt, (*big.Int).Mul,
 				func(a, b float64) float64 { return a * b })
 		case opDiv:
-			i.numberOp("opDiv", func(a, b int64) int64 { return a / b },
-				func(a, b float64) float64 { return a / b })
+			i.numberOp("opDiv", func(a, b int64) (int64, bool) {
+				if b == 0 {
+					runtimeErr("opDiv failed: division by zero")
+				}
+				return a / b, a != math.MinInt64 || b != -1
+			}, func(z, a, b *big.Int) *big.Int {
+				if b.Sign() == 0 {
+					runtimeErr("opDiv failed: division by zero")
+				}
+				return z.Quo(a, b)
+			}, func(a, b float64) float64 { return a / b })
 		case opMod:
-			i.numberOp("opMod", func(a, b int64) int64 { return a % b },
-				func(a, b float64) float64 { runtimeErr("Operator % not defined on float"); return 0 })
+			i.numberOp("opMod", func(a, b int64) (int64, bool) {
+				if b == 0 {
+					runtimeErr("opMod failed: division by zero")
+				}
+				return a % b, true
+			}, func(z, a, b *big.Int) *big.Int {
+				if b.Sign() == 0 {
+					runtimeErr("opMod failed: division by zero")
+				}
+				return z.Rem(a, b)
+			}, func(a, b float64) float64 { runtimeErr("Operator % not defined on float"); return 0 })
+		case opBitAnd:
+			i.intOp("opBitAnd", "&", func(a, b int64) (int64, bool) { return a & b, true }, (*big.Int).And)
+		case opBitOr:
+			i.intOp("opBitOr", "|", func(a, b int64) (int64, bool) { return a | b, true }, (*big.Int).Or)
+		case opBitXor:
+			i.intOp("opBitXor", "^", func(a, b int64) (int64, bool) { return a ^ b, true }, (*big.Int).Xor)
+		case opBitNot:
+			i.push(int64(-1))
+			i.intOp("opBitNot", "~", func(a, b int64) (int64, bool) { return a ^ b, true }, (*big.Int).Xor)
+		case opShl:
+			i.intOp("opShl", "<<", func(a, b int64) (int64, bool) {
+				checkShl(b)
+				return shlInt(a, b)
+			}, func(z, a, b *big.Int) *big.Int {
+				return z.Lsh(a, checkShl(b))
+			})
+		case opShr:
+			i.intOp("opShr", ">>", func(a, b int64) (int64, bool) {
+				checkShift("opShr", b)
+				return a >> uint64(b), true
+			}, func(z, a, b *big.Int) *big.Int {
+				return z.Rsh(a, checkShift("opShr", b))
+			})
 		case opReadInt:
 			var val int64
 			i.read(&val, "%d")
@@ -211,26 +359,212 @@ func (i *interpreter) run() interface{} {
 			if n > len(i.stack) {
 				runtimeErr("opPrint failed: not enough parameters on the stack")
 			}
-			fmt.Fprint(i.stdout, i.stack[len(i.stack)-n:]...)
+			fmt.Fprint(i.stdout, printable(i.stack[len(i.stack)-n:])...)
 			i.stack = i.stack[:len(i.stack)-n]
 		case opPrintLn:
 			n := getInt(op.param, "opPrintLn failed: param not int")
 			if n > len(i.stack) {
 				runtimeErr("opPrintLn failed: not enough parameters on the stack")
 			}
-			fmt.Fprintln(i.stdout, i.stack[len(i.stack)-n:]...)
+			fmt.Fprintln(i.stdout, printable(i.stack[len(i.stack)-n:])...)
 			i.stack = i.stack[:len(i.stack)-n]
 		case opPop:
 			i.pop()
 		case opReturn:
 			if getInt(op.param, "opReturn failed: param not int") == 0 {
-				return nil
+				return nil, 0, true
+			}
+			return i.pop(), 0, true
+		case opLine:
+			pos, ok := op.param.(ast.Pos)
+			if !ok {
+				runtimeErr("opLine failed: non-position param")
+			}
+			i.pos = pos
+		case opCallee:
+			i.callee = getString(op.param, "opCallee failed: non-string param")
+		case opName:
+			i.name = getString(op.param, "opName failed: non-string param")
+		case opDeclare:
+			name := getString(op.param, "opDeclare failed: non-string param")
+			val := i.pop()
+			if i.funcs == nil {
+				i.funcs = make(map[string]interface{})
+			}
+			i.funcs[name] = val
+			i.store(name, val)
+		case opArgs:
+			a, ok := op.param.(arity)
+			if !ok {
+				runtimeErr("opArgs failed: non-arity param")
+			}
+			if n := len(i.stack); n < a.min || a.max >= 0 && n > a.max {
+				name := i.name
+				if name == "" {
+					name = "anonymous function"
+				}
+				runtimeErr(fmt.Sprintf("opArgs failed: %s expects %v, got %d", name, a, n))
+			}
+		case opJmpArg:
+			if len(i.stack) > 0 {
+				ic += getInt(op.param, "opJmpArg failed: non-int param") - 1
+			}
+		case opRest:
+			name := getString(op.param, "opRest failed: non-string param")
+			n := len(i.stack)
+			for k := 0; k < n; k++ {
+				i.store(name+"["+strconv.Itoa(k)+"]", i.pop())
+			}
+			i.store(name, int64(n))
+		case opPack:
+			t := make(tuple, getInt(op.param, "opPack failed: non-int param"))
+			for k := len(t) - 1; k >= 0; k-- {
+				t[k] = i.pop()
+			}
+			i.push(t)
+		case opUnpack:
+			n := getInt(op.param, "opUnpack failed: non-int param")
+			val := i.pop()
+			t, ok := val.(tuple)
+			if !ok {
+				t = tuple{val}
+			}
+			if len(t) != n {
+				runtimeErr(fmt.Sprintf("opUnpack failed: expected %d values, got %d", n, len(t)))
+			}
+			for k := n - 1; k >= 0; k-- {
+				i.push(t[k])
+			}
+		case opSwap:
+			a := i.pop()
+			b := i.pop()
+			i.push(a)
+			i.push(b)
+		case opRange:
+			args := make([]interface{}, getInt(op.param, "opRange failed: non-int param"))
+			for k := len(args) - 1; k >= 0; k-- {
+				args[k] = i.pop()
+			}
+			i.push(newRange(args))
+		case opIter:
+			val := i.pop()
+			it, ok := iterate(val)
+			if !ok {
+				runtimeErr("opIter failed: can not iterate over " + typeName(val))
+			}
+			i.push(it)
+		case opNext:
+			var it iterator
+			if n := len(i.stack); n > 0 {
+				it, _ = i.stack[n-1].(iterator)
+			}
+			if it == nil {
+				runtimeErr("opNext failed: not an iterator")
+			}
+			if val, ok := it.next(); ok {
+				i.push(val)
+			} else {
+				i.pop()
+				ic += getInt(op.param, "opNext failed: non-int param") - 1
+			}
+		case opRecord:
+			r := newRecord()
+			vals := make([]interface{}, 2*getInt(op.param, "opRecord failed: non-int param"))
+			for k := len(vals) - 1; k >= 0; k-- {
+				vals[k] = i.pop()
+			}
+			for k := 0; k < len(vals); k += 2 {
+				r.set(getString(vals[k], "opRecord failed: non-string field name"), vals[k+1])
+			}
+			i.push(r)
+		case opField:
+			name := getString(op.param, "opField failed: non-string param")
+			x := i.pop()
+			if e, ok := x.(*RuntimeError); ok {
+				val, ok := e.field(name)
+				if !ok {
+					runtimeErr("opField failed: error has no field " + name)
+				}
+				i.push(val)
+				break
+			}
+			val, ok := getRecord(x, "opField failed").get(name)
+			if !ok {
+				runtimeErr("opField failed: record has no field " + name)
+			}
+			i.push(val)
+		case opSetField:
+			val := i.pop()
+			getRecord(i.pop(), "opSetField failed").set(getString(op.param, "opSetField failed: non-string param"), val)
+		case opTry:
+			i.handlers = append(i.handlers, handler{ic + getInt(op.param, "opTry failed: non-int param"), len(i.stack)})
+		case opEndTry:
+			n := getInt(op.param, "opEndTry failed: non-int param")
+			if n > len(i.handlers) {
+				runtimeErr("opEndTry failed: not enough error handlers")
+			}
+			i.handlers = i.handlers[:n]
+		case opThrow:
+			throw(i.pop())
+		case opOpen:
+			mode := "r"
+			if getInt(op.param, "opOpen failed: non-int param") > 1 {
+				mode = getString(i.pop(), "opOpen failed: mode is not a string")
+			}
+			name := getString(i.pop(), "opOpen failed: file name is not a string")
+			i.push(i.open(name, mode, "opOpen failed"))
+		case opFileRead:
+			if line, ok := getFile(i.pop(), "opFileRead failed").readLine("opFileRead failed"); ok {
+				i.push(line)
+			} else {
+				i.push(nil)
+			}
+		case opFileWrite:
+			val := i.pop()
+			getFile(i.pop(), "opFileWrite failed").write(val, "opFileWrite failed")
+			i.push(nil)
+		case opClose:
+			if err := getFile(i.pop(), "opClose failed").close(); err != nil {
+				runtimeErr("opClose failed: " + err.Error())
+			}
+			i.push(nil)
+		case opReadLines:
+			switch v := i.pop().(type) {
+			case string:
+				f := i.open(v, "r", "opReadLines failed")
+				f.closeAtEOF = true
+				i.push(f)
+			default:
+				i.push(getFile(v, "opReadLines failed"))
 			}
-			return i.pop()
+		case opImport:
+			m, ok := op.param.(*module)
+			if !ok {
+				runtimeErr("opImport failed: non-module param")
+			}
+			i.push(i.importModule(m))
 		}
 		ic++
 	}
-	return nil
+	return nil, 0, true
+}
+
+// nil is printed as nilValue
+type nilValue struct{}
+
+func (nilValue) String() string { return "nil" }
+
+// replaces nils in vals
+func printable(vals []interface{}) []interface{} {
+	for k, v := range vals {
+		switch v := v.(type) {
+		case nil:
+			vals[k] = nilValue{}
+		case *RuntimeError:
+			vals[k] = v.text()
+		}
+	}
+	return vals
 }
 
 func itoa(i int64) string {
@@ -247,55 +581,132 @@ func (i *interpreter) read(val interface{}, format string) {
 	}
 }
 
-type intF func(a, b int64) int64
+type intF func(a, b int64) (int64, bool)  // false if the result overflows
+type bigF func(z, a, b *big.Int) *big.Int // sets z to the result
 type floatF func(a, b float64) float64
 type intFbool func(a, b int64) bool
 type floatFbool func(a, b float64) bool
 type boolF func(a, b bool) bool
 type stringFbool func(a, b string) bool
 
-func (i *interpreter) numberOp(name string, fi intF, ff floatF) {
-	switch s1 := i.pop().(type) {
+func (i *interpreter) numberOp(name string, fi intF, fb bigF, ff floatF) {
+	s1 := i.pop()
+	s2 := i.pop()
+	i.push(arith(name, s2, s1, fi, fb, ff))
+}
+
+// applies an arithmetic operator to numbers a and b
+func arith(name string, a, b interface{}, fi intF, fb bigF, ff floatF) interface{} {
+	switch {
+	case !isNumber(b):
+		runtimeErr(name + " failed: wrong type of first argument")
+	case !isNumber(a):
+		runtimeErr(name + " failed: wrong type of second argument")
+	case isInt(a) && isInt(b):
+		return intArith(a, b, fi, fb)
+	}
+	return ff(toFloat(a), toFloat(b))
+}
+
+// applies an operator defined only on integers
+func (i *interpreter) intOp(name, operator string, fi intF, fb bigF) {
+	s1, s2 := i.pop(), i.pop()
+	if !isInt(s1) || !isInt(s2) {
+		runtimeErr(name + " failed: operator " + operator + " is defined only on int")
+	}
+	i.push(intArith(s2, s1, fi, fb))
+}
+
+// returns a valid shift count
+func checkShift(name string, count interface{}) uint {
+	switch c := count.(type) {
 	case int64:
-		switch s2 := i.pop().(type) {
-		case int64:
-			i.push(fi(s2, s1))
-		case float64:
-			i.push(ff(s2, float64(s1)))
-		default:
-			runtimeErr(name + " failed: wrong type of second argument")
+		if c < 0 {
+			runtimeErr(name + " failed: negative shift count " + itoa(c))
 		}
-	case float64:
-		switch s2 := i.pop().(type) {
-		case int64:
-			i.push(ff(float64(s2), s1))
-		case float64:
-			i.push(ff(s2, s1))
-		default:
-			runtimeErr(name + " failed: wrong type of second argument")
+		return uint(c)
+	case *big.Int:
+		if c.IsInt64() {
+			return checkShift(name, c.Int64())
+		}
+		if c.Sign() < 0 {
+			runtimeErr(name + " failed: negative shift count " + c.String())
 		}
-	default:
-		runtimeErr(name + " failed: wrong type of first argument")
 	}
+	runtimeErr(name + " failed: shift count too large")
+	return 0
 }
 
-func (i *interpreter) cmpOp(name string, fi intFbool, ff floatFbool, fb boolF, fs stringFbool) {
-	switch s1 := i.pop().(type) {
+// maximum count of a left shift, so that the result fits in memory
+const maxShift = 1 << 22
+
+// returns a valid count of a left shift
+func checkShl(count interface{}) uint {
+	c := checkShift("opShl", count)
+	if c > maxShift {
+		runtimeErr("opShl failed: shift count too large")
+	}
+	return c
+}
+
+// converts a number or a string to a string for concatenation
+func concatString(v interface{}) (string, bool) {
+	switch v := v.(type) {
 	case int64:
-		switch s2 := i.pop().(type) {
-		case int64:
-			i.push(fi(s2, s1))
-		case float64:
-			i.push(ff(s2, float64(s1)))
-		default:
-			runtimeErr(name + " failed: wrong type of second argument")
-		}
+		return itoa(v), true
+	case *big.Int:
+		return v.String(), true
 	case float64:
-		switch s2 := i.pop().(type) {
-		case int64:
-			i.push(ff(float64(s2), s1))
-		case float64:
-			i.push(ff(s2, s1))
+		return ftoa(v), true
+	case string:
+		return v, true
+	case *RuntimeError:
+		return v.text(), true
+	}
+	return "", false
+}
+
+// returns name of the type of a value
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case int64, *big.Int:
+		return "int"
+	case float64:
+		return "float"
+	case bool:
+		return "bool"
+	case string:
+		return "string"
+	case function, *moduleFunction:
+		return "function"
+	case nil:
+		return "nil"
+	case *RuntimeError:
+		return "error"
+	case rangeValue:
+		return "range"
+	case *record:
+		return "record"
+	case *file:
+		return "file"
+	}
+	return "unknown"
+}
+
+func (i *interpreter) cmpOp(name string, fi intFbool, ff floatFbool, fb boolF, fs stringFbool) {
+	switch s1 := i.pop().(type) {
+	case int64, float64, *big.Int:
+		switch s2 := i.pop(); {
+		case isInt(s1) && isInt(s2):
+			if a, ok := s2.(int64); ok {
+				if b, ok := s1.(int64); ok {
+					i.push(fi(a, b))
+					break
+				}
+			}
+			i.push(fi(int64(toBig(s2).Cmp(toBig(s1))), 0))
+		case isNumber(s2):
+			i.push(ff(toFloat(s2), toFloat(s1)))
 		default:
 			runtimeErr(name + " failed: wrong type of second argument")
 		}
@@ -316,6 +727,74 @@ func (i *interpreter) cmpOp(name string, fi intFbool, ff floatFbool, fb boolF, f
 	}
 }
 
+// returns value of a variable of this interpreter
+func (i *interpreter) load(name string) (interface{}, bool) {
+	if i.frame != nil {
+		if k, ok := i.frame.index[name]; ok && i.slots[k] != (undefinedValue{}) {
+			return i.slots[k], true
+		}
+	}
+	if val, ok := i.vars[name]; ok {
+		return val, true
+	}
+	return i.declared(name)
+}
+
+// looks for a function declared by i or the functions which called it, up to
+// the module defining i
+func (i *interpreter) declared(name string) (interface{}, bool) {
+	for c := i; c != nil; c = c.parent {
+		if f, ok := c.funcs[name]; ok {
+			return f, true
+		}
+	}
+	return nil, false
+}
+
+func (i *interpreter) store(name string, val interface{}) {
+	if i.frame != nil {
+		if k, ok := i.frame.index[name]; ok {
+			i.slots[k] = val
+			return
+		}
+	}
+	if i.vars == nil {
+		i.vars = make(map[string]interface{})
+	}
+	i.vars[name] = val
+}
+
+// reports whether a variable exists, unlike trimDots and load never fails
+func (i *interpreter) defined(name string) bool {
+	for ; len(name) > 0 && name[0] == '.'; name = name[1:] {
+		if i = i.parent; i == nil {
+			return false
+		}
+	}
+	_, ok := i.load(name)
+	return ok
+}
+
+// if any of the two values on the top of the stack is nil, a record or a file,
+// replaces them by the result of comparison for identity (or its negation if
+// eq is false)
+func (i *interpreter) refEq(eq bool) bool {
+	if n := len(i.stack); n < 2 || !isRef(i.stack[n-1]) && !isRef(i.stack[n-2]) {
+		return false
+	}
+	a, b := i.pop(), i.pop()
+	i.push((a == b) == eq)
+	return true
+}
+
+func isRef(v interface{}) bool {
+	switch v.(type) {
+	case nil, *record, *file:
+		return true
+	}
+	return false
+}
+
 func trimDots(i0 *interpreter, name0 string) (i *interpreter, name string) {
 	i = i0
 	for name = name0; len(name) > 0 && name[0] == '.'; name = name[1:] {
@@ -367,8 +846,3 @@ func getString(i interface{}, err string) string {
 	}
 	return ret
 }
-
-func runtimeErr(str string) {
-	fmt.Printf("Runtime error: %s.\n", str)
-	os.Exit(0)
-}
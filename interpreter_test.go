@@ -1,6 +1,14 @@
 package yail
 
-import "os"
+import (
+	"bytes"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mabu/yail/ast"
+)
 
 func ExampleHelloWorld() {
 	runExample(`@println("Labas, pasauli!")`)
@@ -27,6 +35,248 @@ func ExampleFactorial() {
 	// Output: 120
 }
 
+func ExampleBitwise() {
+	runExample(`flags = 0
+	flags = flags | 1 << 3 | 1
+	@println(flags, flags & 8 != 0, flags ^ 1, ~flags, -16 >> 2, 0b1010 & 0b0110)`)
+	// Output: 9 true 8 -10 -4 2
+}
+
+func ExampleBigFactorial() {
+	runExample(`fact = 1
+	for i = 1; i <= 25; i = i + 1 { fact = fact * i }
+	@println(fact, fact / 1000000000000000000000 % 100, fact > 9223372036854775807, -fact)
+	@println("x" + fact, fact + 0.5, fact - fact + 1)`)
+	// Output: 15511210043330985984000000 11 true -15511210043330985984000000
+	// x15511210043330985984000000 1.5511210043330986e+25 1
+}
+
+func ExampleOverflow() {
+	runExample(`max = 9223372036854775807
+	min = -max - 1
+	@println(max + 1, min - 1, min / -1, -min, min * -1, 1 << 64, (1 << 64) >> 60, ~(1 << 64))`)
+	// Output: 9223372036854775808 -9223372036854775809 9223372036854775808 9223372036854775808 9223372036854775808 18446744073709551616 16 -18446744073709551617
+}
+
+func ExampleCondExpr() {
+	runExample(`f = (n) {
+		f = .f
+		return n <= 1 ? 1 : n * f(n - 1)
+	}
+	for i = 0; i < 3; i = i + 1 { @println(i, i % 2 == 0 ? "even" : "odd", f(i + 3)) }`)
+	// Output: 0 even 6
+	// 1 odd 24
+	// 2 even 120
+}
+
+func ExampleNil() {
+	runExample(`f = () { }
+	x = f()
+	g = () {
+		if !defined(y) {
+			y = 1
+		}
+		return defined(y) && defined(.x) && !defined(..x) && !defined(z[1])
+	}
+	@println(x, x == nil, nil != 1, type(x), type(1), type(2.5), type(""), type(true), type(f), type(x == nil))
+	@println(defined(x), defined(z), g())
+	type = "t"
+	defined = 2
+	@println(type, defined, type(type))`)
+	// Output: nil true true nil int float string bool function bool
+	// true false true
+	// t 2 string
+}
+
+func ExampleConversion() {
+	runExample(`@println(int(" 42\n") + 1, int("-7"), int("100000000000000000000"), int(-2.9), int(true), int("x"), int("1.5"))
+	@println(float("2.5") * 2, float(3) / 2, float("1e3"), float(""))
+	@println(str(12) + str(1.5) + str(false) + str(nil))
+	@println(bool(0), bool(2.5), bool("true"), bool("yes"))
+	n = int("abc")
+	if n == nil {
+		@println("not a number")
+	}
+	int, str = 3, "s"
+	@println(str(int) + str)`)
+	// Output: 43 -7 100000000000000000000 -2 1 nil nil
+	// 5 1.5 1000 nil
+	// 121.5falsenil
+	// false true true nil
+	// not a number
+	// 3s
+}
+
+func ExampleFuncDecl() {
+	runExample(`@println(even(10), odd(7), even(3))
+	func even(n) {
+		if n == 0 {
+			return true
+		}
+		return odd(n - 1)
+	}
+	func odd(n) {
+		if n == 0 {
+			return false
+		}
+		return even(n - 1)
+	}`)
+	// Output: true true false
+}
+
+func ExampleParams() {
+	runExample(`sum = (first, step = 1, xs...) {
+		s = first
+		for i = 0; i < xs; i = i + 1 {
+			s = s + xs[i] * step
+		}
+		return s
+	}
+	@println(sum(1), sum(1, 2), sum(1, 2, 3, 4))
+	try {
+		sum()
+	} catch (e) {
+		@println(e)
+	}`)
+	// Output: 1 1 15
+	// 10:3: opArgs failed: sum expects at least 1 argument, got 0
+}
+
+func ExampleMultiAssign() {
+	runExample(`func divmod(a, b) {
+		return a / b, a % b
+	}
+	q, r = divmod(17, 5)
+	@println(q, r)
+	q, r = r, q
+	@println(q, r)
+	try {
+		x = divmod(1, 2)
+	} catch (e) {
+		@println(e)
+	}
+	f = (x) {
+		try {
+			return .divmod(x, 3)
+		} finally {
+			@println("finally")
+		}
+	}
+	c, d = f(10)
+	@println(c, d)`)
+	// Output: 3 2
+	// 2 3
+	// 9:3: opCall failed: 2 values returned where one is expected
+	// finally
+	// 3 1
+}
+
+func ExampleForIn() {
+	runExample(`for i in range(3) {
+		@print(i)
+	}
+	for i in range(10, 0, -4) {
+		@print(" ", i)
+	}
+	@println()
+	for c in "ąž" {
+		@println(c)
+	}
+	in, range = 2, "r"
+	for in in range(in) {
+		@print(in, range)
+	}`)
+	// Output: 012 10 6 2
+	// ą
+	// ž
+	// 0r1r
+}
+
+func ExampleLogical() {
+	runExample(`x = 1
+	n = 0
+	for i in range(3) {
+		a = true || x > 0
+		b = true && x < 0
+		if a && !b {
+			n = n + 1
+		}
+	}
+	@println(n)`)
+	// Output: 3
+}
+
+func ExampleRecord() {
+	runExample(`func heal(r, hp) {
+		r.hp = r.hp + hp
+	}
+	p = {
+		name: "hero",
+		hp: 10,
+	}
+	heal(p, 5)
+	p.pos = {x: 1, y: 2}
+	p.pos.x = p.pos.x + 1
+	@println(p, type(p))
+	for field in p {
+		@println(field)
+	}
+	@println(p == p, p == {}, p.pos.x)
+	x = (p.hp)
+	y = (p.hp + 1) * 2
+	@println(x, y)
+	r = {pos: p.pos}
+	r.self = r
+	r.again = p.pos
+	@println(r)`)
+	// Output: {name: "hero", hp: 15, pos: {x: 2, y: 2}} record
+	// name
+	// hp
+	// pos
+	// true false 2
+	// 15 32
+	// {pos: {x: 2, y: 2}, self: {...}, again: {x: 2, y: 2}}
+}
+
+func ExampleTry() {
+	runExample(`div = (a, b) {
+		try {
+			return a / b
+		} catch (e) {
+			@println("caught:", e)
+			return 0
+		} finally {
+			@println("finally")
+		}
+	}
+	@println(div(7, 2))
+	@println(div(1, 0))
+	try {
+		try {
+			throw("oops")
+		} finally {
+			@println("cleanup")
+		}
+	} catch (e) {
+		@println(type(e), e)
+	}
+	try {
+		x = missing
+	} catch (e) {
+		@println(type(e), "" + e)
+		@println(e.msg, e.line, e.col)
+	}`)
+	// Output: finally
+	// 3
+	// caught: 3:4: opDiv failed: division by zero
+	// finally
+	// 0
+	// cleanup
+	// string oops
+	// error 23:3: opLoadSlot failed: variable missing is undefined
+	// opLoadSlot failed: variable missing is undefined 23 3
+}
+
 func ExamplePrimes() {
 	runExample(`MAX = 100
 	for i = 2; i < MAX; i = i + 1 {
@@ -75,3 +325,74 @@ func ExamplePrimes() {
 func runExample(source string) {
 	Interpret(source, os.Stdin, os.Stdout)
 }
+
+func TestRuntimeError(t *testing.T) {
+	for source, msg := range map[string]string{
+		"a = 1\n@println(a / 0)":                                   "Runtime error: 2:1: opDiv failed: division by zero.",
+		"f = () {\n\tthrow(\"bad\" + 1)\n}\nf()":                   "Runtime error: 2:2: bad1.\n\tf at 2:2\n\tmain at 4:1",
+		"g = f\ng(0)\nfunc f(x) {\n\treturn 1 / x\n}":              "Runtime error: 4:2: opDiv failed: division by zero.\n\tf at 4:2\n\tmain at 2:1",
+		"f = (a, b = 1) {}\nf(1, 2, 3)":                            "Runtime error: 2:1: opArgs failed: f expects 1 to 2 arguments, got 3.\n\tf\n\tmain at 2:1",
+		"f = (a) {}\nf()":                                          "Runtime error: 2:1: opArgs failed: f expects 1 argument, got 0.\n\tf\n\tmain at 2:1",
+		"f = () { return 1, 2 }\na, b, c = f()":                    "Runtime error: 2:1: opUnpack failed: expected 3 values, got 2.",
+		"try {\n\tthrow(1)\n} catch (e) {\n\tthrow(e)\n}":          "Runtime error: 4:2: 1.",
+		"p = {x: 1}\n@println(p.y)":                                "Runtime error: 2:1: opField failed: record has no field y.",
+		"try {\n\tx = 1 / 0\n} catch (e) {\n\t@println(e.name)\n}": "Runtime error: 4:2: opField failed: error has no field name.",
+		"p = 1\np.x = 2":                                           "Runtime error: 2:1: opSetField failed: int is not a record.",
+		"try {\n\tx = 1 % 0\n} catch (e) {\n\tthrow(e)\n}":         "Runtime error: 2:2: opMod failed: division by zero.",
+		"@println(1 << 100000000000)":                              "Runtime error: 1:1: opShl failed: shift count too large.",
+		"n = 1 << 64\n@println(2 << n)":                            "Runtime error: 2:1: opShl failed: shift count too large.",
+		"f = () {\n\ttry {\n\t\tx = 1 / 0\n\t} finally {\n\t\t@println(\"fin\")\n\t}\n}\nf()": "Runtime error: 3:3: opDiv failed: division by zero.\n\tf at 3:3\n\tmain at 8:1",
+		"@println(1 << -1)": "Runtime error: 1:1: opShl failed: negative shift count -1.",
+	} {
+		prog, err := Compile(source, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		if err := prog.Run(strings.NewReader(""), &out); err == nil || err.Error() != msg {
+			t.Errorf("Running %q got error %v, expected %q.", source, err, msg)
+		}
+	}
+}
+
+func TestStackTrace(t *testing.T) {
+	prog, err := Compile(`g = (x) {
+	return 1 / x
+}
+f = (x) {
+	g = ...g
+	try {
+		g(x)
+	} catch (e) {
+		@println(e)
+		throw(e)
+	}
+}
+h = (fn) {
+	fn(0)
+}
+k = (x) { f = ..f; f(x) }
+h(k)`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	err = prog.Run(strings.NewReader(""), &out)
+	e, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("Got error %v, expected a *RuntimeError.", err)
+	}
+	expected := []Frame{
+		{"g", ast.Pos{Line: 2, Col: 2}},
+		{"f", ast.Pos{Line: 7, Col: 3}},
+		{"fn", ast.Pos{Line: 16, Col: 20}},
+		{"h", ast.Pos{Line: 14, Col: 2}},
+		{"main", ast.Pos{Line: 17, Col: 1}},
+	}
+	if !reflect.DeepEqual(e.Trace, expected) {
+		t.Errorf("Got trace %v, expected %v.", e.Trace, expected)
+	}
+	if out.String() != "2:2: opDiv failed: division by zero\n" {
+		t.Errorf("Got output %q.", out.String())
+	}
+}
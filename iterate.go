@@ -0,0 +1,97 @@
+package yail
+
+// This file contains values which can be iterated over by for-in loops:
+// ranges of integers, strings (by runes), records (by field names) and files
+// (by lines).
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// A range of integers created by the builtin function range.
+type rangeValue struct {
+	start, stop, step int64
+}
+
+func (r rangeValue) String() string {
+	return fmt.Sprintf("range(%d, %d, %d)", r.start, r.stop, r.step)
+}
+
+// makes a range like range(stop), range(start, stop) or
+// range(start, stop, step)
+func newRange(args []interface{}) rangeValue {
+	ints := make([]int64, len(args))
+	for k, a := range args {
+		i, ok := a.(int64)
+		if !ok {
+			runtimeErr("opRange failed: " + typeName(a) + " is not a small int")
+		}
+		ints[k] = i
+	}
+	switch len(ints) {
+	case 1:
+		return rangeValue{0, ints[0], 1}
+	case 2:
+		return rangeValue{ints[0], ints[1], 1}
+	}
+	if ints[2] == 0 {
+		runtimeErr("opRange failed: step is zero")
+	}
+	return rangeValue{ints[0], ints[1], ints[2]}
+}
+
+type iterator interface {
+	// returns the next value or false if there are no more
+	next() (interface{}, bool)
+}
+
+// returns an iterator over a value or false if it is not iterable
+func iterate(v interface{}) (iterator, bool) {
+	switch v := v.(type) {
+	case rangeValue:
+		return &rangeIterator{v, v.start, false}, true
+	case string:
+		return &stringIterator{v}, true
+	case *record:
+		return &keyIterator{v.keys}, true
+	case *file:
+		if v.closed {
+			runtimeErr("opIter failed: file " + v.name + " is closed")
+		}
+		return &lineIterator{v}, true
+	}
+	return nil, false
+}
+
+type rangeIterator struct {
+	r    rangeValue
+	cur  int64
+	done bool // cur overflowed
+}
+
+func (it *rangeIterator) next() (interface{}, bool) {
+	if it.done || it.r.step > 0 && it.cur >= it.r.stop || it.r.step < 0 && it.cur <= it.r.stop {
+		return nil, false
+	}
+	val := it.cur
+	var ok bool
+	if it.cur, ok = addInt(it.cur, it.r.step); !ok {
+		it.done = true
+	}
+	return val, true
+}
+
+// iterates over runes of a string, each of them is a string
+type stringIterator struct {
+	s string
+}
+
+func (it *stringIterator) next() (interface{}, bool) {
+	if len(it.s) == 0 {
+		return nil, false
+	}
+	r, size := utf8.DecodeRuneInString(it.s)
+	it.s = it.s[size:]
+	return string(r), true
+}
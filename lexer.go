@@ -4,176 +4,347 @@ package yail
 // This file contains a lexical analyser.
 
 import (
+	"fmt"
+	"math/big"
+	"strconv"
 	"strings"
 	"unicode"
 	"unicode/utf8"
 )
 
-var runeLexeme = map[rune]lexType{
-	'(':  lexLeftPar,
-	')':  lexRightPar,
-	'{':  lexLeftBrace,
-	'}':  lexRightBrace,
-	'-':  lexMinus,
-	'+':  lexPlus,
-	'*':  lexMul,
-	'%':  lexMod,
-	',':  lexComma,
-	'.':  lexDot,
-	'[':  lexLeftBracket,
-	']':  lexRightBracket,
-	';':  lexEos,
-	'\n': lexEos,
-}
-
-// conflicts: Eq-EqEq, Div-comment, Less-Leq, Greater-Geq, Not-Neq
-var twoRunesLexeme = map[string]lexType{
+// Operators are matched by maximal munch: the longest operator which is a
+// prefix of the input is chosen.
+var operators = map[string]lexType{
+	"(":  lexLeftPar,
+	")":  lexRightPar,
+	"{":  lexLeftBrace,
+	"}":  lexRightBrace,
+	"-":  lexMinus,
+	"+":  lexPlus,
+	"*":  lexMul,
+	"/":  lexDiv,
+	"%":  lexMod,
+	",":  lexComma,
+	".":  lexDot,
+	"[":  lexLeftBracket,
+	"]":  lexRightBracket,
+	";":  lexEos,
+	"\n": lexEos,
 	"||": lexOr,
 	"&&": lexAnd,
+	"!":  lexNot,
+	"=":  lexEq,
 	"==": lexEqEq,
 	"!=": lexNeq,
+	">":  lexGreater,
+	"<":  lexLess,
 	">=": lexGeq,
 	"<=": lexLeq,
+	"&":  lexBitAnd,
+	"|":  lexBitOr,
+	"^":  lexBitXor,
+	"~":  lexBitNot,
+	"<<": lexShl,
+	">>": lexShr,
+	"?":  lexQuestion,
+	":":  lexColon,
 }
 
-var stringLexeme = map[string]lexType{
-	"true":     lexBool,
-	"false":    lexBool,
-	"if":       lexIf,
-	"else":     lexElse,
-	"for":      lexFor,
-	"while":    lexWhile,
-	"return":   lexReturn,
-	"@int":     lexReadInt,
-	"@float":   lexReadFloat,
-	"@line":    lexReadLine,
-	"@char":    lexReadChar,
-	"@print":   lexPrint,
-	"@println": lexPrintLn,
-	"@rnd":     lexRnd,
+const maxOperatorLen = 2
+
+// Keywords are looked up after scanning a whole identifier, so a keyword
+// followed by letters or digits is a name.
+var keywords = map[string]lexType{
+	"true":    lexBool,
+	"false":   lexBool,
+	"if":      lexIf,
+	"else":    lexElse,
+	"for":     lexFor,
+	"while":   lexWhile,
+	"return":  lexReturn,
+	"nil":     lexNil,
+	"try":     lexTry,
+	"catch":   lexCatch,
+	"finally": lexFinally,
+	"throw":   lexThrow,
+	"func":    lexFunc,
+	"import":  lexImport,
+}
+
+// Builtin functions named by words are only recognized when they are called,
+// otherwise their names can be used as variables.
+var builtinFuncs = map[string]lexType{
+	"type":    lexTypeOf,
+	"defined": lexDefined,
+	"int":     lexToInt,
+	"float":   lexToFloat,
+	"str":     lexToStr,
+	"bool":    lexToBool,
+	"range":   lexRange,
 }
 
-var conflictingRuneLexeme = map[rune]lexType{
-	'=': lexEq,
-	'/': lexDiv,
-	'<': lexLess,
-	'>': lexGreater,
-	'!': lexNot,
+// Builtins are identifiers prefixed by @.
+var builtins = map[string]lexType{
+	"@int":       lexReadInt,
+	"@float":     lexReadFloat,
+	"@line":      lexReadLine,
+	"@char":      lexReadChar,
+	"@print":     lexPrint,
+	"@println":   lexPrintLn,
+	"@rnd":       lexRnd,
+	"@open":      lexOpen,
+	"@read":      lexRead,
+	"@write":     lexWrite,
+	"@close":     lexClose,
+	"@readLines": lexReadLines,
 }
 
 type lexer struct {
-	input   string
-	pos     int // start position of the current lexeme
-	lexemes chan *lex
+	input    string
+	pos      int  // start position of the current lexeme
+	comments bool // emit comments instead of skipping them
+	done     bool // lexEof or lexError was emitted
 }
 
-func newLexer(input string) *lexer {
-	l := lexer{input: input, lexemes: make(chan *lex, lexemeBuffer)}
-	go l.run()
-	return &l
+func newLexer(input string, comments bool) *lexer {
+	return &lexer{input: input, comments: comments}
 }
 
-func (l *lexer) run() {
-	defer close(l.lexemes)
-Start:
+// returns the next lexeme; lexEof is returned after the end of input or an error
+func (l *lexer) get() *lex {
+	if l.done {
+		return &lex{typ: lexEof, pos: len(l.input)}
+	}
 	for r, s := l.next(); r != eof; r, s = l.next() {
-		if lex, ok := runeLexeme[r]; ok {
-			l.emit(lex, s)
-			continue
-		}
-		if unicode.IsSpace(r) {
+		if r != '\n' && unicode.IsSpace(r) {
 			l.pos += s
 			continue
 		}
-		if r == '/' {
-			l.pos += s
-			switch r2, s2 := l.next(); r2 {
-			case '/': // single line comment
-				l.pos += s2
-				l.skipUntil("\n")
-				l.emit(lexEos, 0)
-				continue Start
-			case '*': // comment
-				l.pos += s2
-				l.skipUntil("*/")
-				continue Start
-			default:
-				l.pos -= s
+		switch {
+		case strings.HasPrefix(l.input[l.pos:], "//"): // single line comment
+			if l.comments {
+				return l.emitComment("\n", false)
 			}
-		}
-		for str, lex := range twoRunesLexeme {
-			if strings.HasPrefix(l.input[l.pos:], str) {
-				l.emit(lex, len(str))
-				continue Start
+			l.skipUntil("\n")
+			return l.emit(lexEos, 0)
+		case strings.HasPrefix(l.input[l.pos:], "/*"): // comment
+			if !strings.Contains(l.input[l.pos+2:], "*/") {
+				return l.emitError(len(l.input)-l.pos, "unterminated comment")
 			}
-		}
-		for str, lex := range stringLexeme {
-			if strings.HasPrefix(l.input[l.pos:], str) {
-				r, _ := utf8.DecodeRuneInString(l.input[l.pos+len(str):])
-				if !isAlphaNum(r) {
-					l.emit(lex, len(str))
-					continue Start
-				}
+			if l.comments {
+				return l.emitComment("*/", true)
 			}
-		}
-		if lex, ok := conflictingRuneLexeme[r]; ok {
-			l.emit(lex, s)
+			l.skipUntil("*/")
 			continue
+		case isDigit(r, 10) || r == '.' && l.pos+1 < len(l.input) && isDigit(rune(l.input[l.pos+1]), 10):
+			return l.number()
+		case r == '"':
+			return l.string(s)
+		case unicode.IsLetter(r):
+			return l.word()
+		case r == '@':
+			return l.identifier(builtins, lexError)
 		}
-		if unicode.IsNumber(r) {
-			i := strings.IndexFunc(l.input[l.pos+s:], notNumber)
-			if i == -1 {
-				l.emit(lexInt, len(l.input)-l.pos)
-			} else if l.input[l.pos+s+i] == '.' {
-				i++
-				j := strings.IndexFunc(l.input[l.pos+s+i:], notNumber)
-				if j == -1 {
-					l.emit(lexFloat, len(l.input)-l.pos)
-				} else {
-					l.emit(lexFloat, s+i+j)
+		for n := maxOperatorLen; n > 0; n-- {
+			if l.pos+n <= len(l.input) {
+				if lex, ok := operators[l.input[l.pos:l.pos+n]]; ok {
+					return l.emit(lex, n)
 				}
-			} else {
-				l.emit(lexInt, s+i)
 			}
-		} else if r == '"' { // string
-			i := strings.Index(l.input[l.pos+s:], "\"")
-			for i != -1 {
-				if (i-strings.LastIndexFunc(l.input[l.pos+s-1:l.pos+s+i], func(r rune) bool { return r != '\\' }))%2 == 0 {
-					break
-				}
-				s += i + 1
-				i = strings.Index(l.input[l.pos+s:], "\"")
+		}
+		return l.emitError(s, "unexpected character %q", r)
+	}
+	l.done = true
+	return l.emit(lexEof, 0)
+}
+
+// scans a numeric literal: an integer (decimal or with a 0x, 0b or 0o prefix)
+// or a decimal float with an optional exponent; digits may be separated by
+// underscores
+func (l *lexer) number() *lex {
+	base, size := 10, 0
+	if len(l.input) > l.pos+1 && l.input[l.pos] == '0' {
+		switch l.input[l.pos+1] {
+		case 'x', 'X':
+			base, size = 16, 2
+		case 'b', 'B':
+			base, size = 2, 2
+		case 'o', 'O':
+			base, size = 8, 2
+		}
+	}
+	// letters and digits following a number are a part of it, e.g. 12ab is
+	// a malformed number rather than 12 followed by a name
+	typ, dot, exp := lexInt, false, false
+	for l.pos+size < len(l.input) {
+		r, s := utf8.DecodeRuneInString(l.input[l.pos+size:])
+		switch {
+		case r == '.' && base == 10 && !dot && !exp:
+			dot, typ = true, lexFloat
+		case (r == 'e' || r == 'E') && base == 10 && !exp:
+			exp, typ = true, lexFloat
+			if n := l.pos + size + 1; n < len(l.input) && (l.input[n] == '+' || l.input[n] == '-') {
+				s++
 			}
-			if i == -1 {
-				l.emit(lexError, len(l.input)-l.pos)
-			} else {
-				l.emit(lexString, s+i+1)
+		case r != '_' && !isAlphaNum(r):
+			return l.checkNumber(typ, size, base)
+		}
+		size += s
+	}
+	return l.checkNumber(typ, size, base)
+}
+
+var baseName = map[int]string{2: "binary", 8: "octal", 10: "decimal", 16: "hexadecimal"}
+
+// emits a numeric literal of the given size or an error if it is malformed
+func (l *lexer) checkNumber(typ lexType, size, base int) *lex {
+	lit := l.input[l.pos : l.pos+size]
+	digits := lit
+	if base != 10 {
+		digits = lit[2:]
+		if strings.Trim(digits, "_") == "" {
+			return l.emitError(size, "%s literal has no digits", baseName[base])
+		}
+	}
+	if i := strings.IndexAny(digits, "eE"); i != -1 && base == 10 {
+		if strings.Trim(digits[i+1:], "+-_") == "" {
+			return l.emitError(size, "exponent has no digits")
+		}
+		if r, _ := utf8.DecodeRuneInString(digits[i+1:]); r == '_' {
+			return l.emitError(size, "'_' must separate successive digits")
+		}
+	}
+	var prev rune
+	if base != 10 { // an underscore may follow the prefix
+		prev = '0'
+	}
+	for _, r := range digits {
+		switch {
+		case r == '_':
+			if !isDigit(prev, base) {
+				return l.emitError(size, "'_' must separate successive digits")
 			}
-		} else if unicode.IsLetter(r) {
-			i := strings.IndexFunc(l.input[l.pos+s:], func(r rune) bool { return !isAlphaNum(r) })
-			if i == -1 {
-				s = len(l.input) - l.pos
-			} else {
-				s += i
+		case r == '.', (r == 'e' || r == 'E' || r == '+' || r == '-') && base == 10:
+			if prev == '_' {
+				return l.emitError(size, "'_' must separate successive digits")
 			}
-			l.emit(lexName, s)
-		} else {
-			l.emit(lexError, s)
-			return
+		case !isDigit(r, base):
+			return l.emitError(size, "invalid digit %q in %s literal", r, baseName[base])
+		}
+		prev = r
+	}
+	if prev == '_' {
+		return l.emitError(size, "'_' must separate successive digits")
+	}
+	return l.emit(typ, size)
+}
+
+func isDigit(r rune, base int) bool {
+	switch {
+	case '0' <= r && r <= '9':
+		return int(r-'0') < base
+	case 'a' <= r && r <= 'f':
+		return base == 16
+	case 'A' <= r && r <= 'F':
+		return base == 16
+	}
+	return false
+}
+
+// returns value of an integer literal: int64 or *big.Int if it does not fit
+func intValue(lit string) (interface{}, error) {
+	lit = strings.Replace(lit, "_", "", -1)
+	base := 10
+	if len(lit) > 1 && lit[0] == '0' {
+		switch lit[1] {
+		case 'x', 'X':
+			base = 16
+		case 'b', 'B':
+			base = 2
+		case 'o', 'O':
+			base = 8
+		}
+	}
+	if base != 10 {
+		lit = lit[2:]
+	}
+	i, err := strconv.ParseInt(lit, base, 64)
+	if err, ok := err.(*strconv.NumError); ok && err.Err == strconv.ErrRange {
+		if b, ok := new(big.Int).SetString(lit, base); ok {
+			return b, nil
 		}
 	}
-	l.emit(lexEof, 0)
+	if err != nil {
+		return nil, err
+	}
+	return i, nil
 }
 
-func notNumber(r rune) bool { return !unicode.IsNumber(r) }
+// returns value of a float literal
+func floatValue(lit string) (float64, error) {
+	return strconv.ParseFloat(strings.Replace(lit, "_", "", -1), 64)
+}
 
-func (l *lexer) get() *lex {
-	return <-l.lexemes
+func (l *lexer) string(s int) *lex {
+	i := strings.Index(l.input[l.pos+s:], "\"")
+	for i != -1 {
+		// the quote is escaped if it follows an odd number of backslashes
+		end := l.pos + s + i
+		k := end
+		for l.input[k-1] == '\\' {
+			k--
+		}
+		if (end-k)%2 == 0 {
+			break
+		}
+		s += i + 1
+		i = strings.Index(l.input[l.pos+s:], "\"")
+	}
+	if i == -1 {
+		return l.emitError(len(l.input)-l.pos, "string literal not terminated")
+	}
+	return l.emit(lexString, s+i+1)
+}
+
+// scans an identifier (its first rune is not checked) and looks it up in
+// table; identifiers not in the table are of type other
+func (l *lexer) identifier(table map[string]lexType, other lexType) *lex {
+	_, s := l.next()
+	if i := strings.IndexFunc(l.input[l.pos+s:], func(r rune) bool { return !isAlphaNum(r) }); i == -1 {
+		s = len(l.input) - l.pos
+	} else {
+		s += i
+	}
+	if lex, ok := table[l.input[l.pos:l.pos+s]]; ok {
+		return l.emit(lex, s)
+	}
+	if other == lexError {
+		return l.emitError(s, "unknown builtin %s", l.input[l.pos:l.pos+s])
+	}
+	return l.emit(other, s)
 }
 
-func (l *lexer) emit(t lexType, size int) {
-	l.lexemes <- &lex{typ: t, val: l.input[l.pos : l.pos+size]}
+// lexes a name, a keyword or a builtin function followed by (
+func (l *lexer) word() *lex {
+	lex := l.identifier(keywords, lexName)
+	if t, ok := builtinFuncs[lex.val]; ok && lex.typ == lexName && strings.HasPrefix(strings.TrimLeft(l.input[l.pos:], " \t"), "(") {
+		lex.typ = t
+	}
+	return lex
+}
+
+func (l *lexer) emit(t lexType, size int) *lex {
+	ret := &lex{typ: t, val: l.input[l.pos : l.pos+size], pos: l.pos}
 	l.pos += size
+	return ret
+}
+
+// emits lexError and stops lexing
+func (l *lexer) emitError(size int, format string, args ...interface{}) *lex {
+	l.done = true
+	ret := l.emit(lexError, size)
+	ret.err = fmt.Sprintf(format, args...)
+	return ret
 }
 
 func isAlphaNum(r rune) bool {
@@ -184,11 +355,20 @@ func (l *lexer) next() (r rune, size int) {
 	if l.pos >= len(l.input) {
 		return eof, 0
 	}
-	r, size = utf8.DecodeRuneInString(l.input[l.pos:])
-	if r == utf8.RuneError {
-		panic("utf8.RuneError")
+	return utf8.DecodeRuneInString(l.input[l.pos:])
+}
+
+// emits a comment ending with s (or at the end of input)
+func (l *lexer) emitComment(s string, inclusive bool) *lex {
+	size := strings.Index(l.input[l.pos+2:], s)
+	if size == -1 {
+		size = len(l.input) - l.pos
+	} else if inclusive {
+		size += 2 + len(s)
+	} else {
+		size += 2
 	}
-	return
+	return l.emit(lexComment, size)
 }
 
 func (l *lexer) skipUntil(s string) {
@@ -1,63 +1,205 @@
 package yail
 
-import "testing"
+import (
+	"strings"
+	"testing"
+	"unicode"
+)
 
 func TestParenthesis(t *testing.T) {
-	runLexTest(t, "()", []lex{{lexLeftPar, "("}, {lexRightPar, ")"}})
+	runLexTest(t, "()", []lex{{typ: lexLeftPar, val: "("}, {typ: lexRightPar, val: ")"}})
 }
 
 func TestBraces(t *testing.T) {
-	runLexTest(t, "}{", []lex{{lexRightBrace, "}"}, {lexLeftBrace, "{"}})
+	runLexTest(t, "}{", []lex{{typ: lexRightBrace, val: "}"}, {typ: lexLeftBrace, val: "{"}})
 }
 
 func TestArithmetic(t *testing.T) {
-	runLexTest(t, "-+*/%", []lex{{lexMinus, "-"}, {lexPlus, "+"}, {lexMul, "*"}, {lexDiv, "/"}, {lexMod, "%"}})
+	runLexTest(t, "-+*/%", []lex{{typ: lexMinus, val: "-"}, {typ: lexPlus, val: "+"}, {typ: lexMul, val: "*"}, {typ: lexDiv, val: "/"}, {typ: lexMod, val: "%"}})
 }
 
 func TestPunctuation(t *testing.T) {
-	runLexTest(t, ",.", []lex{{lexComma, ","}, {lexDot, "."}})
+	runLexTest(t, ",.", []lex{{typ: lexComma, val: ","}, {typ: lexDot, val: "."}})
 }
 
 func TestEos(t *testing.T) {
-	runLexTest(t, ";\n", []lex{{lexEos, ";"}, {lexEos, "\n"}})
+	runLexTest(t, ";\n", []lex{{typ: lexEos, val: ";"}, {typ: lexEos, val: "\n"}})
 }
 
 func TestLogic(t *testing.T) {
-	runLexTest(t, "!||&&", []lex{{lexNot, "!"}, {lexOr, "||"}, {lexAnd, "&&"}})
+	runLexTest(t, "!||&&", []lex{{typ: lexNot, val: "!"}, {typ: lexOr, val: "||"}, {typ: lexAnd, val: "&&"}})
+}
+
+func TestBitwise(t *testing.T) {
+	runLexTest(t, "& | ^ ~ << >> &&<<=", []lex{{typ: lexBitAnd, val: "&"}, {typ: lexBitOr, val: "|"}, {typ: lexBitXor, val: "^"}, {typ: lexBitNot, val: "~"}, {typ: lexShl, val: "<<"}, {typ: lexShr, val: ">>"}, {typ: lexAnd, val: "&&"}, {typ: lexShl, val: "<<"}, {typ: lexEq, val: "="}})
 }
 
 func TestCompareOp(t *testing.T) {
-	runLexTest(t, "< <= >= > != ==", []lex{{lexLess, "<"}, {lexLeq, "<="}, {lexGeq, ">="}, {lexGreater, ">"}, {lexNeq, "!="}, {lexEqEq, "=="}})
+	runLexTest(t, "< <= >= > != ==", []lex{{typ: lexLess, val: "<"}, {typ: lexLeq, val: "<="}, {typ: lexGeq, val: ">="}, {typ: lexGreater, val: ">"}, {typ: lexNeq, val: "!="}, {typ: lexEqEq, val: "=="}})
 }
 
 func TestName(t *testing.T) {
-	runLexTest(t, "xe123b[58]", []lex{{lexName, "xe123b"}, {lexLeftBracket, "["}, {lexInt, "58"}, {lexRightBracket, "]"}})
+	runLexTest(t, "xe123b[58]", []lex{{typ: lexName, val: "xe123b"}, {typ: lexLeftBracket, val: "["}, {typ: lexInt, val: "58"}, {typ: lexRightBracket, val: "]"}})
 }
 
 func TestFloatAssign(t *testing.T) {
-	runLexTest(t, "f = 0.543 -0.234 16.", []lex{{lexName, "f"}, {lexEq, "="}, {lexFloat, "0.543"}, {lexMinus, "-"}, {lexFloat, "0.234"}, {lexFloat, "16."}})
+	runLexTest(t, "f = 0.543 -0.234 16.", []lex{{typ: lexName, val: "f"}, {typ: lexEq, val: "="}, {typ: lexFloat, val: "0.543"}, {typ: lexMinus, val: "-"}, {typ: lexFloat, val: "0.234"}, {typ: lexFloat, val: "16."}})
+}
+
+func TestNumber(t *testing.T) {
+	runLexTest(t, "0x1F 0B1_0 0o17 0 1_000_000 1e-9 2.5E+3 .5 1_0.0_1e1_0 0x_ff 07", []lex{
+		{typ: lexInt, val: "0x1F"}, {typ: lexInt, val: "0B1_0"}, {typ: lexInt, val: "0o17"}, {typ: lexInt, val: "0"},
+		{typ: lexInt, val: "1_000_000"}, {typ: lexFloat, val: "1e-9"}, {typ: lexFloat, val: "2.5E+3"}, {typ: lexFloat, val: ".5"},
+		{typ: lexFloat, val: "1_0.0_1e1_0"}, {typ: lexInt, val: "0x_ff"}, {typ: lexInt, val: "07"}})
+	runLexTest(t, "a.b[.5]", []lex{{typ: lexName, val: "a"}, {typ: lexDot, val: "."}, {typ: lexName, val: "b"},
+		{typ: lexLeftBracket, val: "["}, {typ: lexFloat, val: ".5"}, {typ: lexRightBracket, val: "]"}})
+}
+
+func TestMalformedNumber(t *testing.T) {
+	for input, err := range map[string]string{
+		"0x":     "hexadecimal literal has no digits",
+		"0b_ ":   "binary literal has no digits",
+		"0b102":  "invalid digit '2' in binary literal",
+		"0o8":    "invalid digit '8' in octal literal",
+		"12ab":   "invalid digit 'a' in decimal literal",
+		"1e":     "exponent has no digits",
+		"1.5e+":  "exponent has no digits",
+		"1__0":   "'_' must separate successive digits",
+		"1_":     "'_' must separate successive digits",
+		"1_.5":   "'_' must separate successive digits",
+		"1._5":   "'_' must separate successive digits",
+		"1e_5":   "'_' must separate successive digits",
+		"\u0663": "unexpected character '\u0663'",
+	} {
+		got := newLexer(input, false).get()
+		if got.typ != lexError || got.err != err {
+			t.Errorf("Lexing %q got %v, expected error %q.", input, got, err)
+		}
+	}
 }
 
 func TestKeyword(t *testing.T) {
-	runLexTest(t, "if ifa while whileb for for3 returni return", []lex{{lexIf, "if"}, {lexName, "ifa"}, {lexWhile, "while"}, {lexName, "whileb"}, {lexFor, "for"}, {lexName, "for3"}, {lexName, "returni"}, {lexReturn, "return"}})
+	runLexTest(t, "if ifa while whileb for for3 returni return", []lex{{typ: lexIf, val: "if"}, {typ: lexName, val: "ifa"}, {typ: lexWhile, val: "while"}, {typ: lexName, val: "whileb"}, {typ: lexFor, val: "for"}, {typ: lexName, val: "for3"}, {typ: lexName, val: "returni"}, {typ: lexReturn, val: "return"}})
+}
+
+func TestBuiltinFunc(t *testing.T) {
+	runLexTest(t, "type(x) defined (y) typex type", []lex{{typ: lexTypeOf, val: "type"}, {typ: lexLeftPar, val: "("}, {typ: lexName, val: "x"}, {typ: lexRightPar, val: ")"},
+		{typ: lexDefined, val: "defined"}, {typ: lexLeftPar, val: "("}, {typ: lexName, val: "y"}, {typ: lexRightPar, val: ")"}, {typ: lexName, val: "typex"}, {typ: lexName, val: "type"}})
 }
 
 func TestBool(t *testing.T) {
-	runLexTest(t, "true1 true false falseb", []lex{{lexName, "true1"}, {lexBool, "true"}, {lexBool, "false"}, {lexName, "falseb"}})
+	runLexTest(t, "true1 true false falseb", []lex{{typ: lexName, val: "true1"}, {typ: lexBool, val: "true"}, {typ: lexBool, val: "false"}, {typ: lexName, val: "falseb"}})
 }
 
 func TestString(t *testing.T) {
-	runLexTest(t, `"lorem \\ ipsum šlept\\\n \\\" \"foo\"" bar ""`, []lex{{lexString, `"lorem \\ ipsum šlept\\\n \\\" \"foo\""`}, {lexName, "bar"}, {lexString, `""`}})
+	runLexTest(t, `"lorem \\ ipsum šlept\\\n \\\" \"foo\"" bar ""`, []lex{{typ: lexString, val: `"lorem \\ ipsum šlept\\\n \\\" \"foo\""`}, {typ: lexName, val: "bar"}, {typ: lexString, val: `""`}})
+	runLexTest(t, `"ž" "\\" "\\\""`, []lex{{typ: lexString, val: `"ž"`}, {typ: lexString, val: `"\\"`}, {typ: lexString, val: `"\\\""`}})
+}
+
+func TestComment(t *testing.T) {
+	input := "a // one\n/* two\n */b /* three"
+	runLexTest(t, input, []lex{{typ: lexName, val: "a"}, {typ: lexEos, val: ""}, {typ: lexName, val: "b"}, {typ: lexError, val: "/* three"}})
+	runCommentLexTest(t, input, []lex{{typ: lexName, val: "a"}, {typ: lexComment, val: "// one"}, {typ: lexEos, val: "\n"}, {typ: lexComment, val: "/* two\n */"}, {typ: lexName, val: "b"}, {typ: lexError, val: "/* three"}})
+}
+
+func TestBuiltin(t *testing.T) {
+	runLexTest(t, "@print @println(@int)", []lex{{typ: lexPrint, val: "@print"}, {typ: lexPrintLn, val: "@println"}, {typ: lexLeftPar, val: "("}, {typ: lexReadInt, val: "@int"}, {typ: lexRightPar, val: ")"}})
+	runLexTest(t, "@printx", []lex{{typ: lexError, val: "@printx"}})
+}
+
+func TestLongestMatch(t *testing.T) {
+	runLexTest(t, "<== !== === >=>", []lex{{typ: lexLeq, val: "<="}, {typ: lexEq, val: "="}, {typ: lexNeq, val: "!="}, {typ: lexEq, val: "="}, {typ: lexEqEq, val: "=="}, {typ: lexEq, val: "="}, {typ: lexGeq, val: ">="}, {typ: lexGreater, val: ">"}})
 }
 
 func runLexTest(t *testing.T, input string, expect []lex) {
-	lexer := newLexer(input)
+	testLexer(t, newLexer(input, false), expect)
+}
+
+func runCommentLexTest(t *testing.T, input string, expect []lex) {
+	testLexer(t, newLexer(input, true), expect)
+}
+
+func testLexer(t *testing.T, lexer *lexer, expect []lex) {
 	for _, l := range expect {
-		if got := lexer.get(); l != *got {
+		if got := lexer.get(); l.typ != got.typ || l.val != got.val {
 			t.Errorf("Got %v, expected %v.", got, l)
 		}
 	}
-	if got := lexer.get(); *got != (lex{lexEof, ""}) {
+	if got := lexer.get(); got.typ != lexEof || got.val != "" {
 		t.Errorf("Expected EOF, got %v.", got)
 	}
 }
+
+func TestPosition(t *testing.T) {
+	lexer := newLexer("a = 1\n  b", false)
+	for _, pos := range []int{0, 2, 4, 5, 8, 9} {
+		if got := lexer.get(); got.pos != pos {
+			t.Errorf("Got %v at %d, expected position %d.", got, got.pos, pos)
+		}
+	}
+}
+
+func lexAll(input string, comments bool) []*lex {
+	l := newLexer(input, comments)
+	var ret []*lex
+	for i := 0; i <= len(input)+1; i++ {
+		lex := l.get()
+		ret = append(ret, lex)
+		if lex.typ == lexEof {
+			break
+		}
+	}
+	return ret
+}
+
+func FuzzLexer(f *testing.F) {
+	for _, seed := range []string{
+		"a = 1\n", "if ifa else elsewhere", "@print @println @printx", "<== !== ===",
+		"x = 0.5 // c\n/* d */ y", `"a\"b" "unterminated`, "$", "\u00e9t\u00e9 = 3", "f = (a, b) { return a[b] }", "type(type) defined (x)",
+	} {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		lexemes := lexAll(input, true)
+		if last := lexemes[len(lexemes)-1]; last.typ != lexEof {
+			t.Fatalf("Lexer did not finish: %v.", last)
+		}
+		for i, l := range lexAll(input, true) {
+			if *l != *lexemes[i] {
+				t.Fatalf("Lexing is not deterministic: got %v, then %v.", lexemes[i], l)
+			}
+		}
+		end := 0
+		for _, l := range lexemes {
+			if l.pos < end || !strings.HasPrefix(input[l.pos:], l.val) {
+				t.Fatalf("Lexeme %v is not at position %d.", l, l.pos)
+			}
+			if gap := input[end:l.pos]; strings.TrimSpace(gap) != "" || strings.Contains(gap, "\n") {
+				t.Fatalf("Skipped %q before %v.", gap, l)
+			}
+			end = l.pos + len(l.val)
+			if l.typ == lexError || l.typ == lexEof {
+				break
+			}
+			// a lexeme is matched the same way without its context,
+			// except builtin functions which are names unless called
+			alone := lexAll(l.val, true)
+			expect := l.typ
+			if t, ok := builtinFuncs[l.val]; ok && t == l.typ {
+				expect = lexName
+			}
+			if len(alone) != 2 || alone[0].typ != expect || alone[0].val != l.val {
+				t.Fatalf("Lexeme %v is lexed as %v alone.", l, alone)
+			}
+			// names and keywords are never followed by letters or digits
+			if l.typ == lexName || keywords[l.val] == l.typ || builtins[l.val] == l.typ {
+				for _, r := range input[end:] {
+					if unicode.IsLetter(r) || unicode.IsNumber(r) {
+						t.Fatalf("Lexeme %v is followed by %q.", l, r)
+					}
+					break
+				}
+			}
+		}
+	})
+}
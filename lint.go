@@ -0,0 +1,469 @@
+package yail
+
+// This file contains a static analyser reporting common mistakes.
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mabu/yail/ast"
+)
+
+// A Problem is a possible mistake found by Lint.
+type Problem struct {
+	Pos ast.Pos
+	Msg string
+}
+
+func (p Problem) String() string {
+	return p.Pos.String() + ": " + p.Msg
+}
+
+// Lint checks a syntax tree for variables read before any assignment,
+// unreachable code, too many dots in variable names, constant or non-bool
+// conditions and assignments which are never used. Problems are sorted by
+// their position.
+func Lint(file *ast.File) []Problem {
+	var l linter
+	l.function(nil, nil, file.Stmts)
+	sort.SliceStable(l.problems, func(i, j int) bool {
+		return l.problems[i].Pos.Before(l.problems[j].Pos)
+	})
+	return l.problems
+}
+
+type linter struct {
+	problems []Problem
+}
+
+// variables of a function
+type lintScope struct {
+	parent   *lintScope
+	params   map[string]bool
+	assigned map[string]ast.Pos // first assignment
+	used     map[string]bool
+	funcs    map[string]bool   // declared functions
+	loops    []map[string]bool // variables assigned in the enclosing loops
+}
+
+func (l *linter) report(pos ast.Pos, format string, args ...interface{}) {
+	l.problems = append(l.problems, Problem{pos, fmt.Sprintf(format, args...)})
+}
+
+// fun is nil for the main program
+func (l *linter) function(parent *lintScope, fun *ast.FuncLit, stmts []ast.Stmt) {
+	s := &lintScope{parent: parent, params: make(map[string]bool),
+		assigned: make(map[string]ast.Pos), used: make(map[string]bool), funcs: make(map[string]bool)}
+	if fun != nil {
+		for k, p := range fun.Params {
+			if fun.Defaults != nil && fun.Defaults[k] != nil { // may use the previous parameters
+				l.expr(s, fun.Defaults[k])
+			}
+			s.params[p.Name] = true
+			s.assigned[p.Name] = p.Pos()
+		}
+		if fun.Ellipsis.IsValid() { // the rest of the arguments are in a pseudo-array
+			rest := fun.Params[len(fun.Params)-1]
+			s.params[rest.Name+"[]"] = true
+			s.assigned[rest.Name+"[]"] = rest.Pos()
+		}
+	}
+	l.stmts(s, stmts)
+	for name, pos := range s.assigned {
+		if !s.used[name] && !s.params[name] {
+			l.report(pos, "%s is assigned but never used", name)
+		}
+	}
+}
+
+func (l *linter) stmts(s *lintScope, stmts []ast.Stmt) {
+	// function declarations are hoisted, so they are never unreachable
+	decls := make([]*ast.FuncDecl, 0)
+	rest := make([]ast.Stmt, 0, len(stmts))
+	for _, st := range stmts {
+		if d, ok := st.(*ast.FuncDecl); ok {
+			decls = append(decls, d)
+		} else {
+			if imp, ok := st.(*ast.ImportStmt); ok { // like functions, visible to the called ones
+				s.funcs[imp.Name.Name] = true
+			}
+			rest = append(rest, st)
+		}
+	}
+	for _, d := range decls {
+		s.funcs[d.Name.Name] = true
+		s.params[d.Name.Name] = true // like Go, unused functions are fine
+		if _, ok := s.assigned[d.Name.Name]; !ok {
+			s.assigned[d.Name.Name] = d.Name.Pos()
+		}
+	}
+	for _, d := range decls {
+		l.function(s, d.Fun, d.Fun.Body.Stmts)
+	}
+	stmts = rest
+	for i, st := range stmts {
+		l.stmt(s, st)
+		if terminates(st) && i+1 < len(stmts) {
+			l.report(stmts[i+1].Pos(), "unreachable code")
+			for _, st := range stmts[i+1:] {
+				l.stmt(s, st)
+			}
+			return
+		}
+	}
+}
+
+// reports whether execution never continues after s
+func terminates(s ast.Stmt) bool {
+	switch s := s.(type) {
+	case *ast.ReturnStmt:
+		return true
+	case *ast.IfStmt:
+		return s.Else != nil && blockTerminates(s.Body) && blockTerminates(s.Else)
+	case *ast.ThrowStmt:
+		return true
+	case *ast.TryStmt:
+		if s.Finally != nil && blockTerminates(s.Finally) {
+			return true
+		}
+		return blockTerminates(s.Body) && (s.Catch == nil || blockTerminates(s.Catch))
+	}
+	return false
+}
+
+func blockTerminates(b *ast.Block) bool {
+	for _, s := range b.Stmts {
+		if terminates(s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *linter) stmt(s *lintScope, st ast.Stmt) {
+	switch st := st.(type) {
+	case *ast.AssignStmt:
+		l.assign(s, st)
+	case *ast.CallStmt:
+		l.expr(s, st.Call)
+	case *ast.BuiltinStmt:
+		l.expr(s, st.Call)
+	case *ast.PrintStmt:
+		for _, x := range st.Args {
+			l.expr(s, x)
+		}
+	case *ast.MultiAssignStmt:
+		l.multiAssign(s, st)
+	case *ast.ReturnStmt:
+		for _, x := range st.Values {
+			l.expr(s, x)
+		}
+	case *ast.IfStmt:
+		l.cond(s, st.Cond, false)
+		l.stmts(s, st.Body.Stmts)
+		if st.Else != nil {
+			l.stmts(s, st.Else.Stmts)
+		}
+	case *ast.ForStmt:
+		if st.Init != nil {
+			l.assign(s, st.Init)
+		}
+		l.loop(s, st)
+		l.cond(s, st.Cond, true)
+		l.stmts(s, st.Body.Stmts)
+		if st.Post != nil {
+			l.assign(s, st.Post)
+		}
+		s.loops = s.loops[:len(s.loops)-1]
+	case *ast.ForInStmt:
+		l.expr(s, st.X)
+		switch typ := staticType(st.X); typ {
+		case "", "range", "string", "record", "file":
+		default:
+			l.report(st.X.Pos(), "can not iterate over %s", typ)
+		}
+		l.loop(s, st)
+		l.store(s, st.Var)
+		l.stmts(s, st.Body.Stmts)
+		s.loops = s.loops[:len(s.loops)-1]
+	case *ast.WhileStmt:
+		l.loop(s, st)
+		l.cond(s, st.Cond, true)
+		l.stmts(s, st.Body.Stmts)
+		s.loops = s.loops[:len(s.loops)-1]
+	case *ast.TryStmt:
+		l.stmts(s, st.Body.Stmts)
+		if st.Param != nil { // like a parameter, it does not have to be used
+			s.params[st.Param.Name] = true
+			if _, ok := s.assigned[st.Param.Name]; !ok {
+				s.assigned[st.Param.Name] = st.Param.Pos()
+			}
+		}
+		if st.Catch != nil {
+			l.stmts(s, st.Catch.Stmts)
+		}
+		if st.Finally != nil {
+			l.stmts(s, st.Finally.Stmts)
+		}
+	case *ast.ImportStmt:
+		l.store(s, st.Name)
+	case *ast.ThrowStmt:
+		l.expr(s, st.Value)
+	}
+}
+
+// remembers variables assigned anywhere in a loop: they may be read before
+// the assignment in the next iteration
+func (l *linter) loop(s *lintScope, loop ast.Stmt) {
+	assigned := make(map[string]bool)
+	ast.Inspect(loop, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit:
+			return false
+		case *ast.AssignStmt:
+			if n.Target.Dots == 0 && len(n.Target.Fields) == 0 {
+				assigned[varKey(n.Target)] = true
+			}
+		case *ast.ForInStmt:
+			if len(n.Var.Fields) == 0 {
+				assigned[varKey(n.Var)] = true
+			}
+		case *ast.ImportStmt:
+			assigned[varKey(n.Name)] = true
+		case *ast.MultiAssignStmt:
+			for _, t := range n.Targets {
+				if t.Dots == 0 && len(t.Fields) == 0 {
+					assigned[varKey(t)] = true
+				}
+			}
+		}
+		return true
+	})
+	s.loops = append(s.loops, assigned)
+}
+
+func (l *linter) assign(s *lintScope, st *ast.AssignStmt) {
+	if len(st.Target.Fields) == 0 {
+		for _, x := range st.Target.Index {
+			l.expr(s, x)
+		}
+	}
+	l.expr(s, st.Value)
+	l.store(s, st.Target)
+}
+
+// values are computed before indices of the targets, like in codegen
+func (l *linter) multiAssign(s *lintScope, st *ast.MultiAssignStmt) {
+	for _, x := range st.Values {
+		l.expr(s, x)
+	}
+	for _, n := range st.Targets {
+		if len(n.Fields) == 0 {
+			for _, x := range n.Index {
+				l.expr(s, x)
+			}
+		}
+		l.store(s, n)
+	}
+}
+
+// remembers the first assignment to n; assigning to a field reads the record
+func (l *linter) store(s *lintScope, n *ast.Name) {
+	if len(n.Fields) > 0 {
+		l.read(s, n)
+		return
+	}
+	if target := l.scope(s, n); target != nil {
+		key := varKey(n)
+		if _, ok := target.assigned[key]; !ok {
+			target.assigned[key] = n.Pos()
+		}
+	}
+}
+
+// returns the scope a name refers to, nil if there are too many dots
+func (l *linter) scope(s *lintScope, n *ast.Name) *lintScope {
+	for i := 0; i < n.Dots; i++ {
+		if s = s.parent; s == nil {
+			l.report(n.Pos(), "too many dots in %s: only %d enclosing %s", nameString(n), i, plural(i, "function"))
+			return nil
+		}
+	}
+	return s
+}
+
+// variables of pseudo-arrays are identified by their name without indices
+func varKey(n *ast.Name) string {
+	if len(n.Index) > 0 {
+		return n.Name + "[]"
+	}
+	return n.Name
+}
+
+func nameString(n *ast.Name) string {
+	ret := strings.Repeat(".", n.Dots) + n.Name
+	if len(n.Index) > 0 {
+		ret += "[...]"
+	}
+	return ret
+}
+
+func (l *linter) read(s *lintScope, n *ast.Name) {
+	for _, x := range n.Index {
+		l.expr(s, x)
+	}
+	target := l.scope(s, n)
+	if target == nil {
+		return
+	}
+	key := varKey(n)
+	target.used[key] = true
+	if n.Dots > 0 { // values of other scopes depend on the caller
+		return
+	}
+	if _, ok := s.assigned[key]; ok {
+		return
+	}
+	for _, assigned := range s.loops {
+		if assigned[key] {
+			return
+		}
+	}
+	for d := s.parent; d != nil; d = d.parent {
+		if d.funcs[key] { // visible to the functions called by d
+			d.used[key] = true
+			return
+		}
+	}
+	l.report(n.Pos(), "%s is read before any assignment", nameString(n))
+}
+
+func (l *linter) expr(s *lintScope, x ast.Expr) {
+	switch x := x.(type) {
+	case *ast.Name:
+		l.read(s, x)
+	case *ast.FuncLit:
+		l.function(s, x, x.Body.Stmts)
+	case *ast.RecordLit:
+		for _, field := range x.Fields {
+			l.expr(s, field.Value)
+		}
+	case *ast.UnaryExpr:
+		l.expr(s, x.X)
+	case *ast.BinaryExpr:
+		l.expr(s, x.X)
+		l.expr(s, x.Y)
+	case *ast.BuiltinCall:
+		if x.Name != "defined" {
+			for _, a := range x.Args {
+				l.expr(s, a)
+			}
+			break
+		}
+		n := x.Args[0].(*ast.Name)
+		for _, i := range n.Index {
+			l.expr(s, i)
+		}
+		if target := l.scope(s, n); target != nil {
+			target.used[varKey(n)] = true
+		}
+	case *ast.CondExpr:
+		l.cond(s, x.Cond, false)
+		l.expr(s, x.X)
+		l.expr(s, x.Y)
+	case *ast.ParenExpr:
+		l.expr(s, x.X)
+	case *ast.CallExpr:
+		l.read(s, x.Fun)
+		for _, a := range x.Args {
+			l.expr(s, a)
+		}
+	}
+}
+
+// checks a condition of if (loop == false) or a loop
+func (l *linter) cond(s *lintScope, x ast.Expr, loop bool) {
+	l.expr(s, x)
+	switch typ := staticType(x); typ {
+	case "", "bool":
+	default:
+		l.report(x.Pos(), "condition is %s, not bool", typ)
+		return
+	}
+	if b, ok := unparen(x).(*ast.BasicLit); ok && b.Kind == ast.Bool {
+		if !loop {
+			l.report(x.Pos(), "condition is always %s", b.Value)
+		} else if b.Value == "false" {
+			l.report(x.Pos(), "loop body is never executed")
+		}
+	}
+}
+
+func unparen(x ast.Expr) ast.Expr {
+	for {
+		p, ok := x.(*ast.ParenExpr)
+		if !ok {
+			return x
+		}
+		x = p.X
+	}
+}
+
+var builtinType = map[string]string{
+	"@int":   "int",
+	"@float": "float",
+	"@line":  "string",
+	"@char":  "string",
+	"@rnd":   "int",
+}
+
+var builtinCallType = map[string]string{
+	"type":       "string",
+	"defined":    "bool",
+	"str":        "string",
+	"range":      "range",
+	"@open":      "file",
+	"@readLines": "file",
+}
+
+// returns type of an expression if it does not depend on variables
+func staticType(x ast.Expr) string {
+	switch x := x.(type) {
+	case *ast.BasicLit:
+		return [...]string{ast.Int: "int", ast.Float: "float", ast.String: "string", ast.Bool: "bool", ast.Nil: "nil"}[x.Kind]
+	case *ast.BuiltinCall:
+		return builtinCallType[x.Name]
+	case *ast.RecordLit:
+		return "record"
+	case *ast.Builtin:
+		return builtinType[x.Name]
+	case *ast.ParenExpr:
+		return staticType(x.X)
+	case *ast.CondExpr:
+		if t := staticType(x.X); t == staticType(x.Y) {
+			return t
+		}
+	case *ast.UnaryExpr:
+		if x.Op == "!" {
+			return "bool"
+		}
+		return staticType(x.X)
+	case *ast.BinaryExpr:
+		switch x.Op {
+		case "||", "&&", "==", "!=", "<", ">", "<=", ">=":
+			return "bool"
+		}
+		t1, t2 := staticType(x.X), staticType(x.Y)
+		switch {
+		case t1 == "" || t2 == "":
+			return ""
+		case t1 == "string" || t2 == "string":
+			return "string"
+		case t1 == "float" || t2 == "float":
+			return "float"
+		}
+		return t1
+	}
+	return ""
+}
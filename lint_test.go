@@ -0,0 +1,202 @@
+package yail
+
+import "testing"
+
+func TestLintClean(t *testing.T) {
+	runLintTest(t, `fun = (x) {
+		fun = .fun
+		if x == 0 {
+			return 1
+		} else {
+			return x * fun(x - 1)
+		}
+	}
+	@print(fun(5))
+	for i = 2; i < 10; i = i + 1 {
+		if i > 2 {
+			@println(prev, isPrime[i])
+		}
+		prev = i
+		isPrime[i] = true
+	}`)
+}
+
+func TestLintUndefined(t *testing.T) {
+	runLintTest(t, `a = b + 1
+	@println(a, c[a])
+	f = (x) {
+		return x + a
+	}
+	f(1)`,
+		"1:5: b is read before any assignment",
+		"2:14: c[...] is read before any assignment",
+		"4:14: a is read before any assignment")
+}
+
+func TestLintDefined(t *testing.T) {
+	runLintTest(t, `if !defined(x) {
+		x = 1
+	}
+	@println(x, type(y))`,
+		"4:19: y is read before any assignment")
+}
+
+func TestLintTry(t *testing.T) {
+	runLintTest(t, `try {
+		throw(1)
+		a = 2
+	} catch (e) {
+	}
+	try {
+		return
+	} finally {
+		throw(2)
+	}
+	b = 3`,
+		"3:3: unreachable code",
+		"3:3: a is assigned but never used",
+		"11:2: unreachable code",
+		"11:2: b is assigned but never used")
+}
+
+func TestLintFuncDecl(t *testing.T) {
+	runLintTest(t, `@println(f(1))
+	return
+	func f(x) {
+		return g(x) + y
+	}
+	func g(x) {
+		return x
+	}
+	func unused() {}`,
+		"4:17: y is read before any assignment")
+}
+
+func TestLintParams(t *testing.T) {
+	runLintTest(t, `f = (a, b = a + c, rest...) {
+		return rest[0]
+	}
+	@println(f(1))`,
+		"1:17: c is read before any assignment")
+}
+
+func TestLintForIn(t *testing.T) {
+	runLintTest(t, `for i in range(3) {
+		if i > 0 {
+			@println(i, last)
+		}
+		last = i
+	}
+	for c in 1.5 {
+		@println(c)
+	}`,
+		"7:11: can not iterate over float")
+}
+
+func TestLintRecord(t *testing.T) {
+	runLintTest(t, `p.x = 1
+	q = {x: 1, y: z}
+	q.y = 2`,
+		"1:1: p is read before any assignment",
+		"2:16: z is read before any assignment")
+}
+
+func TestLintImport(t *testing.T) {
+	runLintTest(t, `import "a.yail" as a
+	import "b.yail" as b
+	f = () {
+		return a.x
+	}
+	@println(f())`,
+		"2:21: b is assigned but never used")
+}
+
+func TestLintFiles(t *testing.T) {
+	runLintTest(t, `f = @open("a.txt", "w")
+	@write(f, x)
+	@close(f)
+	for line in @readLines("b.txt") {
+		@println(line)
+	}`,
+		"2:12: x is read before any assignment")
+}
+
+func TestLintUnreachable(t *testing.T) {
+	runLintTest(t, `f = (x) {
+		if x {
+			return 1
+		} else {
+			return 2
+		}
+		@println("never")
+	}
+	f(true)`, "7:3: unreachable code")
+}
+
+func TestLintDots(t *testing.T) {
+	runLintTest(t, `x = 1
+	f = () {
+		@println(.x, ..x)
+		...y = 2
+	}
+	f()
+	g = () {
+		h = () { @println(...x) }
+		h()
+	}
+	g()`,
+		"3:16: too many dots in ..x: only 1 enclosing function",
+		"4:3: too many dots in ...y: only 1 enclosing function",
+		"8:21: too many dots in ...x: only 2 enclosing functions")
+}
+
+func TestLintConditions(t *testing.T) {
+	runLintTest(t, `if 1 + 2 {
+	}
+	while "x" {
+	}
+	if (true) {
+	}
+	while false {
+	}
+	while @int > 0 && @line != "" {
+	}
+	@println(1 ? 2 : 3, true ? 4 : 5)`,
+		"1:4: condition is int, not bool",
+		"3:8: condition is string, not bool",
+		"5:5: condition is always true",
+		"7:8: loop body is never executed",
+		"11:11: condition is int, not bool",
+		"11:22: condition is always true")
+}
+
+func TestLintUnused(t *testing.T) {
+	runLintTest(t, `a = 1
+	b = 2
+	f = (x, y) {
+		.c = x
+		z = 3
+	}
+	f(b)`,
+		"1:1: a is assigned but never used",
+		"4:3: c is assigned but never used",
+		"5:3: z is assigned but never used")
+}
+
+func runLintTest(t *testing.T, source string, expect ...string) {
+	file, err := ParseFile(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := Lint(file)
+	for i := 0; i < len(got) || i < len(expect); i++ {
+		switch {
+		case i >= len(got):
+			t.Errorf("Missing problem %q.", expect[i])
+		case i >= len(expect):
+			t.Errorf("Unexpected problem %q.", got[i])
+		case got[i].String() != expect[i]:
+			t.Errorf("Got problem %q, expected %q.", got[i], expect[i])
+		}
+	}
+}
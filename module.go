@@ -0,0 +1,199 @@
+package yail
+
+// This file contains modules: files imported by a program with
+// import "file.yail" as name. Modules are read by a Loader and compiled
+// together with the program, each of them once. They are run when they are
+// imported for the first time.
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mabu/yail/ast"
+)
+
+// A compiled module.
+type module struct {
+	name string // given by the Loader
+	f    function
+}
+
+// A Loader reads source code of the modules imported by programs.
+type Loader interface {
+	// Load returns the source code of the module imported by
+	// import "path" and its name, e.g. a file name. Modules with the same
+	// name are compiled once. The error wraps fs.ErrNotExist if the module
+	// does not exist.
+	Load(path string) (name string, source []byte, err error)
+}
+
+// FSLoader returns a Loader which reads modules from fsys. An import path is
+// looked for in each of dirs in order, "." if there are none. Names of the
+// modules are their paths in fsys.
+func FSLoader(fsys fs.FS, dirs ...string) Loader {
+	if len(dirs) == 0 {
+		dirs = []string{"."}
+	}
+	return fsLoader{fsys, dirs}
+}
+
+type fsLoader struct {
+	fsys fs.FS
+	dirs []string
+}
+
+func (l fsLoader) Load(p string) (string, []byte, error) {
+	for _, dir := range l.dirs {
+		name := path.Join(dir, p)
+		source, err := fs.ReadFile(l.fsys, name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		return name, source, err
+	}
+	return "", nil, fs.ErrNotExist
+}
+
+// compiles a program and the modules imported by it
+type loader struct {
+	opts    *Options
+	modules map[string]*module // by name
+	loading []string           // names of the modules being compiled
+}
+
+func newLoader(opts *Options) *loader {
+	return &loader{opts: opts, modules: make(map[string]*module)}
+}
+
+// compiles source code of a file; name is empty for the main program
+func (ld *loader) compile(name, source string) function {
+	file := ld.parse(name, source)
+	imports := make(map[*ast.ImportStmt]*module)
+	ast.Inspect(file, func(n ast.Node) bool {
+		if s, ok := n.(*ast.ImportStmt); ok {
+			imports[s] = ld.load(name, s)
+		}
+		return true
+	})
+	f := gen(file, imports, true, true)
+	if !ld.opts.NoOptimize {
+		f = optimize(f)
+	}
+	return f
+}
+
+// parses source code of a file, adds its name to parse errors
+func (ld *loader) parse(name, source string) *ast.File {
+	defer func() {
+		if r := recover(); r != nil {
+			if pe, ok := r.(parseError); ok && name != "" {
+				r = parseError("Parse error: " + name + ": " + strings.TrimPrefix(string(pe), "Parse error: "))
+			}
+			panic(r)
+		}
+	}()
+	return parseFile(source, false)
+}
+
+// returns the module imported by s in the file name, compiles it if it was
+// not imported before
+func (ld *loader) load(name string, s *ast.ImportStmt) *module {
+	p, _ := strconv.Unquote(s.Path.Value)
+	if ld.opts.Loader == nil {
+		fileErr(name, s.Path.Pos(), fmt.Sprintf("can not import %q: no loader in options", p))
+	}
+	modName, source, err := ld.opts.Loader.Load(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		fileErr(name, s.Path.Pos(), fmt.Sprintf("module %q not found", p))
+	} else if err != nil {
+		fileErr(name, s.Path.Pos(), fmt.Sprintf("can not import %q: %v", p, err))
+	}
+	for k, l := range ld.loading {
+		if l == modName {
+			fileErr(name, s.Path.Pos(), "import cycle: "+strings.Join(append(ld.loading[k:], modName), " -> "))
+		}
+	}
+	if m, ok := ld.modules[modName]; ok {
+		return m
+	}
+	ld.loading = append(ld.loading, modName)
+	m := &module{modName, ld.compile(modName, string(source))}
+	ld.loading = ld.loading[:len(ld.loading)-1]
+	ld.modules[modName] = m
+	return m
+}
+
+// panics with a parse error at pos of the module name (empty for the main
+// program)
+func fileErr(name string, pos ast.Pos, msg string) {
+	if name != "" {
+		name += ": "
+	}
+	panic(parseError(fmt.Sprintf("Parse error: %s%v: %s.", name, pos, msg)))
+}
+
+// A function defined by a module. Unlike other functions, it sees the
+// variables and the functions declared by its module, not by its caller.
+type moduleFunction struct {
+	f      function
+	module *interpreter // which ran the module
+}
+
+func (f *moduleFunction) String() string {
+	return fmt.Sprint(f.f)
+}
+
+// runs a module unless it was imported before, returns its variables
+func (i *interpreter) importModule(m *module) *record {
+	if r, ok := i.imports[m]; ok {
+		if r == nil {
+			runtimeErr("opImport failed: import cycle in " + m.name)
+		}
+		return r
+	}
+	i.imports[m] = nil // being imported
+	defer func() {
+		if i.imports[m] == nil { // failed
+			delete(i.imports, m)
+		}
+	}()
+	mi := &interpreter{stdin: i.stdin, stdout: i.stdout, f: m.f, stack: make([]interface{}, 0), ops: i.ops, name: m.name, imports: i.imports, files: i.files}
+	mi.run()
+	r := mi.exports()
+	i.imports[m] = r
+	return r
+}
+
+// returns a record of the top-level variables of a module, except arrays
+func (i *interpreter) exports() *record {
+	r := newRecord()
+	export := func(name string, val interface{}) {
+		if f, ok := val.(function); ok {
+			val = &moduleFunction{f, i}
+		}
+		r.set(name, val)
+	}
+	if i.frame != nil {
+		for k, name := range i.frame.names {
+			if i.slots[k] != (undefinedValue{}) {
+				export(name, i.slots[k])
+			}
+		}
+	}
+	names := make([]string, 0, len(i.vars))
+	for name := range i.vars {
+		if !strings.Contains(name, "[") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		export(name, i.vars[name])
+	}
+	return r
+}
@@ -0,0 +1,101 @@
+package yail
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestImport(t *testing.T) {
+	prog, err := Compile(`import "util.yail" as util
+import "shapes.yail" as shapes
+@println(util.name, util.double(21), shapes.area(3, 4), util.greet("bob"), type(util.twice))
+for v in util {
+	@print(v, " ")
+}
+f = () {
+	import "util.yail" as as
+	return as == util
+}
+@println(f())`, &Options{Loader: FSLoader(os.DirFS(filepath.Join("testdata", "modules")), ".", "lib")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "loading util\nutil 42 12 hi bob function\nname double twice greet true\n"
+	var out bytes.Buffer
+	if err := prog.Run(strings.NewReader(""), &out); err != nil || out.String() != expect {
+		t.Errorf("Got output %q and error %v, expected %q.", out.String(), err, expect)
+	}
+	var buf bytes.Buffer
+	if err := prog.Encode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if prog, err = Decode(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out.Reset()
+	if err := prog.Run(strings.NewReader(""), &out); err != nil || out.String() != expect {
+		t.Errorf("Decoded program got output %q and error %v, expected %q.", out.String(), err, expect)
+	}
+}
+
+func TestImportState(t *testing.T) {
+	fsys := fstest.MapFS{"counter.yail": {Data: []byte(`count = 5
+next = () {
+	.count = .count + 1
+	return .count
+}
+get = () { return .count }`)}}
+	opts := &Options{Loader: FSLoader(fsys)}
+	for source, expect := range map[string]string{
+		"count = 100\nimport \"counter.yail\" as c\n@println(c.next(), c.next(), c.get(), count)": "6 7 7 100\n",
+		"import \"counter.yail\" as c\nf = () { return c.get() }\n@println(f())":                  "5\n",
+	} {
+		prog, err := Compile(source, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		if err := prog.Run(strings.NewReader(""), &out); err != nil || out.String() != expect {
+			t.Errorf("Source %q got output %q and error %v, expected %q.", source, out.String(), err, expect)
+		}
+	}
+}
+
+func TestImportError(t *testing.T) {
+	fsys := fstest.MapFS{
+		"a.yail":      {Data: []byte(`import "b.yail" as b`)},
+		"b.yail":      {Data: []byte(`import "a.yail" as a`)},
+		"broken.yail": {Data: []byte("x = (\n")},
+		"lib/fail.yail": {Data: []byte(`func fail() {
+	return 1 / 0
+}`)},
+		"dir.yail/x.yail": {},
+	}
+	opts := &Options{Loader: FSLoader(fsys, ".", "lib")}
+	for source, msg := range map[string]string{
+		`import "missing.yail" as m`:            `Parse error: 1:8: module "missing.yail" not found.`,
+		`import "a.yail" as a`:                  "Parse error: b.yail: 1:8: import cycle: a.yail -> b.yail -> a.yail.",
+		`import "broken.yail" as b`:             `Parse error: broken.yail: 1:6: expected int, float, string, bool, nil, name or call, got "\n".`,
+		`import "a.yail" to a`:                  `Parse error: 1:17: expected as, got "to".`,
+		"import \"fail.yail\" as f\n\nf.fail()": "Runtime error: 2:2: opDiv failed: division by zero.\n\tfail at 2:2\n\tmain at 3:1",
+	} {
+		prog, err := Compile(source, opts)
+		if err == nil {
+			err = prog.Run(strings.NewReader(""), new(bytes.Buffer))
+		}
+		if err == nil || err.Error() != msg {
+			t.Errorf("Source %q got error %v, expected %q.", source, err, msg)
+		}
+	}
+	// the error of reading a directory depends on fs.FS
+	if _, err := Compile(`import "dir.yail" as d`, opts); err == nil || !strings.HasPrefix(err.Error(), `Parse error: 1:8: can not import "dir.yail": `) {
+		t.Errorf("Got error %v.", err)
+	}
+	if _, err := Compile(`import "a.yail" as a`, nil); err == nil || err.Error() != `Parse error: 1:8: can not import "a.yail": no loader in options.` {
+		t.Errorf("Got error %v.", err)
+	}
+}
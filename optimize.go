@@ -0,0 +1,253 @@
+package yail
+
+// This file contains a bytecode optimizer. It folds operations on constants,
+// removes useless jumps and unreachable code and replaces loads of variables
+// with static names by opLoad.
+
+func optimize(f function) function {
+	f = append(make(function, 0, len(f)), f...)
+	for i, o := range f {
+		if o.typ == opFunction {
+			f[i].param = optimize(o.param.(function))
+		}
+	}
+	for changed := true; changed; {
+		changed = false
+		for _, pass := range []func(function) (function, bool){fold, removeJumps, removeDead, fuseLoads} {
+			var c bool
+			f, c = pass(f)
+			changed = changed || c
+		}
+	}
+	return f
+}
+
+// jumps and opTry whose param is the position of the error handler
+func isJump(t opType) bool {
+	return t == opJmp || t == opJmpFalse || t == opTry || t == opJmpArg || t == opNext
+}
+
+// returns which ops (including the end of the function) are jump targets
+func jumpTargets(f function) []bool {
+	targets := make([]bool, len(f)+1)
+	for i, o := range f {
+		if isJump(o.typ) {
+			if t := i + o.param.(int); t >= 0 && t <= len(f) {
+				targets[t] = true
+			}
+		}
+	}
+	return targets
+}
+
+// removes ops marked in del; jumps to removed ops go to the next kept op
+func removeOps(f function, del []bool) function {
+	idx := make([]int, len(f)+1) // new index of the op
+	for i := range f {
+		idx[i+1] = idx[i]
+		if !del[i] {
+			idx[i+1]++
+		}
+	}
+	ret := make(function, 0, idx[len(f)])
+	for i, o := range f {
+		if del[i] {
+			continue
+		}
+		if isJump(o.typ) {
+			if t := i + o.param.(int); t >= 0 && t <= len(f) {
+				o.param = idx[t] - idx[i]
+			}
+		}
+		ret = append(ret, o)
+	}
+	return ret
+}
+
+func isUnary(t opType) bool {
+	return t == opNeg || t == opNot || t == opBitNot
+}
+
+// returns the value of a constant op
+func constant(o op) (interface{}, bool) {
+	switch o.typ {
+	case opInt, opFloat, opBool, opString:
+		return o.param, true
+	}
+	return nil, false
+}
+
+// returns a constant op with value val, false if there is no such op (e.g.
+// for big integers)
+func constOp(val interface{}) (op, bool) {
+	switch val.(type) {
+	case int64:
+		return op{opInt, val}, true
+	case float64:
+		return op{opFloat, val}, true
+	case bool:
+		return op{opBool, val}, true
+	case string:
+		return op{opString, val}, true
+	}
+	return op{}, false
+}
+
+// folds unary and binary operations on constants
+func fold(f function) (function, bool) {
+	targets := jumpTargets(f)
+	del := make([]bool, len(f))
+	changed := false
+	for i := 0; i+1 < len(f); i++ {
+		a, ok := constant(f[i])
+		if !ok || targets[i+1] {
+			continue
+		}
+		if isUnary(f[i+1].typ) {
+			if !foldable(f[i+1].typ, a, a) {
+				continue
+			}
+			if c, ok := constOp(eval(f[i], f[i+1])); ok {
+				f[i] = c
+				del[i+1] = true
+				changed = true
+				i++
+			}
+			continue
+		}
+		if i+2 >= len(f) || targets[i+2] {
+			continue
+		}
+		if b, ok := constant(f[i+1]); ok && !isUnary(f[i+2].typ) && foldable(f[i+2].typ, a, b) {
+			c, ok := constOp(eval(f[i], f[i+1], f[i+2]))
+			if !ok {
+				continue
+			}
+			f[i] = c
+			del[i+1], del[i+2] = true, true
+			changed = true
+			i += 2
+		}
+	}
+	if !changed {
+		return f, false
+	}
+	return removeOps(f, del), true
+}
+
+// evaluates ops on constants using the interpreter
+func eval(ops ...op) interface{} {
+	return newInterpreter(append(function(ops), op{opReturn, 1}), nil, nil).run()
+}
+
+// reports whether op t can be applied to constants a and b (or only to b if
+// the op is unary) without a runtime error
+func foldable(t opType, a, b interface{}) bool {
+	_, aInt := a.(int64)
+	_, aFloat := a.(float64)
+	_, aBool := a.(bool)
+	_, aString := a.(string)
+	_, bInt := b.(int64)
+	_, bFloat := b.(float64)
+	_, bBool := b.(bool)
+	_, bString := b.(string)
+	numbers := (aInt || aFloat) && (bInt || bFloat)
+	switch t {
+	case opSum:
+		return !aBool && !bBool
+	case opSub, opMul, opNeg:
+		return numbers
+	case opDiv:
+		return numbers && (!aInt || !bInt || b.(int64) != 0)
+	case opMod:
+		return aInt && bInt && b.(int64) != 0
+	case opNot:
+		return bBool
+	case opBitAnd, opBitOr, opBitXor:
+		return aInt && bInt
+	case opBitNot:
+		return bInt
+	case opShl:
+		return aInt && bInt && b.(int64) >= 0 && b.(int64) <= maxShift
+	case opShr:
+		return aInt && bInt && b.(int64) >= 0
+	case opOr, opAnd:
+		return aBool && bBool
+	case opEq, opNeq:
+		return numbers || (aBool && bBool) || (aString && bString)
+	case opLess, opGreater, opLeq, opGeq:
+		return numbers || (aString && bString)
+	}
+	return false
+}
+
+// removes jumps to the next op; conditional ones are replaced by opPop
+func removeJumps(f function) (function, bool) {
+	del := make([]bool, len(f))
+	changed := false
+	for i, o := range f {
+		if (o.typ == opJmp || o.typ == opJmpFalse) && o.param.(int) == 1 {
+			if o.typ == opJmp {
+				del[i] = true
+			} else {
+				f[i] = op{opPop, nil}
+			}
+			changed = true
+		}
+	}
+	if !changed {
+		return f, false
+	}
+	return removeOps(f, del), true
+}
+
+// removes ops which can never be executed, e.g. after opReturn
+func removeDead(f function) (function, bool) {
+	reachable := make([]bool, len(f))
+	todo := []int{0}
+	for len(todo) > 0 {
+		i := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+		for ; i >= 0 && i < len(f) && !reachable[i]; i++ {
+			reachable[i] = true
+			o := f[i]
+			if isJump(o.typ) {
+				todo = append(todo, i+o.param.(int))
+			}
+			if o.typ == opJmp || o.typ == opReturn || o.typ == opThrow {
+				break
+			}
+		}
+	}
+	del := make([]bool, len(f))
+	changed := false
+	for i := range f {
+		if !reachable[i] {
+			del[i] = true
+			changed = true
+		}
+	}
+	if !changed {
+		return f, false
+	}
+	return removeOps(f, del), true
+}
+
+// replaces opString and opLoadStr by opLoad
+func fuseLoads(f function) (function, bool) {
+	targets := jumpTargets(f)
+	del := make([]bool, len(f))
+	changed := false
+	for i := 0; i+1 < len(f); i++ {
+		if f[i].typ == opString && f[i+1].typ == opLoadStr && !targets[i+1] {
+			f[i] = op{opLoad, f[i].param}
+			del[i+1] = true
+			changed = true
+			i++
+		}
+	}
+	if !changed {
+		return f, false
+	}
+	return removeOps(f, del), true
+}
@@ -0,0 +1,121 @@
+package yail
+
+import "testing"
+
+func TestFold(t *testing.T) {
+	runOptimizeTest(t, `a = 5 + 4 * (3 - 7.5 / 2.5) + "x" + -(1 - 2)
+	b = 1 < 2.5 == !false`, function{
+		op{opString, "a"},
+		op{opString, "5x1"},
+		op{opStoreStr, nil},
+		op{opString, "b"},
+		op{opBool, true},
+		op{opStoreStr, nil}})
+	runOptimizeTest(t, "a = 3 * -1", function{
+		op{opString, "a"},
+		op{opInt, int64(-3)},
+		op{opStoreStr, nil}})
+	runOptimizeTest(t, `@println(MAX * 2 + 1, 1 / 0, 2.5 % 2, true + 1)`, function{
+		op{opLoad, "MAX"},
+		op{opInt, int64(2)},
+		op{opMul, nil},
+		op{opInt, int64(1)},
+		op{opSum, nil},
+		op{opInt, int64(1)},
+		op{opInt, int64(0)},
+		op{opDiv, nil},
+		op{opFloat, 2.5},
+		op{opInt, int64(2)},
+		op{opMod, nil},
+		op{opBool, true},
+		op{opInt, int64(1)},
+		op{opSum, nil},
+		op{opPrintLn, 4}})
+}
+
+func TestFoldBitwise(t *testing.T) {
+	runOptimizeTest(t, `@println(0xf0 | 0x0f ^ 3 & ~1 << 4, 1 << -1, 1.5 & 1)`, function{
+		op{opInt, int64(0xff ^ 32)},
+		op{opInt, int64(1)},
+		op{opInt, int64(-1)},
+		op{opShl, nil},
+		op{opFloat, 1.5},
+		op{opInt, int64(1)},
+		op{opBitAnd, nil},
+		op{opPrintLn, 3}})
+}
+
+func TestFoldOverflow(t *testing.T) {
+	runOptimizeTest(t, `@println(9223372036854775807 + 1)`, function{
+		op{opInt, int64(9223372036854775807)},
+		op{opInt, int64(1)},
+		op{opSum, nil},
+		op{opPrintLn, 1}})
+}
+
+func TestFoldName(t *testing.T) {
+	runOptimizeTest(t, "..name[5] = b[a]", function{
+		op{opString, "..name[5]"},
+		op{opString, "b["},
+		op{opLoad, "a"},
+		op{opSum, nil},
+		op{opString, "]"},
+		op{opSum, nil},
+		op{opLoadStr, nil},
+		op{opStoreStr, nil}})
+}
+
+func TestRemoveJumps(t *testing.T) {
+	runOptimizeTest(t, "if a { } else { b = 1 }\nif c { }", function{
+		op{opLoad, "a"},
+		op{opJmpFalse, 2},
+		op{opJmp, 4},
+		op{opString, "b"},
+		op{opInt, int64(1)},
+		op{opStoreStr, nil},
+		op{opLoad, "c"},
+		op{opPop, nil}})
+	runOptimizeTest(t, "if 1 < 2 { a = 1 } else { }", function{
+		op{opBool, true},
+		op{opJmpFalse, 4},
+		op{opString, "a"},
+		op{opInt, int64(1)},
+		op{opStoreStr, nil}})
+}
+
+func TestRemoveDead(t *testing.T) {
+	runOptimizeTest(t, `f = (x) {
+		while x > 0 {
+			return 1
+			x = x - 1
+		}
+		if x {
+			return 2
+		} else {
+			return 3
+		}
+		@println("never")
+	}`, function{
+		op{opString, "f"},
+		op{opFunction, function{
+			op{opArgs, arity{1, 1}},
+			op{opStore, "x"},
+			op{opLoad, "x"},
+			op{opInt, int64(0)},
+			op{opGreater, nil},
+			op{opJmpFalse, 3},
+			op{opInt, int64(1)},
+			op{opReturn, 1},
+			op{opLoad, "x"},
+			op{opJmpFalse, 3},
+			op{opInt, int64(2)},
+			op{opReturn, 1},
+			op{opInt, int64(3)},
+			op{opReturn, 1},
+		}},
+		op{opStoreStr, nil}})
+}
+
+func runOptimizeTest(t *testing.T, source string, expect function) {
+	testFun(t, optimize(parse(source)), expect)
+}
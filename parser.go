@@ -1,354 +1,662 @@
 package yail
 
-// This file contains YAIL parser. It uses a lexer and produces a bytecode.
+// This file contains YAIL parser. It uses a lexer and produces a syntax tree.
 
 import (
 	"fmt"
-	"os"
+	"sort"
 	"strconv"
+
+	"github.com/mabu/yail/ast"
 )
 
 type parser struct {
-	l   *lexer
-	nxt []*lex
+	l        *lexer
+	nxt      []*lex
+	lines    lineTable
+	comments []*ast.Comment
 }
 
-func parse(source string) function {
-	p := parser{newLexer(source), make([]*lex, 0)}
-	return p.fun(make(function, 0))
+// Options control compilation of a program.
+type Options struct {
+	NoOptimize bool   // disables the bytecode optimizer, e.g. for debugging
+	Loader     Loader // reads imported modules; imports fail if it is nil
 }
 
-// f contains function loading function arguments
-func (p *parser) fun(f function) function {
-	endCriteria := lexEof
-	if p.next(0).typ == lexLeftBrace {
-		endCriteria = lexRightBrace
-		p.skip(1)
+// Compiles a program given its source code.
+// A nil opts means the default options.
+func Compile(source string, opts *Options) (prog *Program, err error) {
+	defer catchParseErr(&err)
+	if opts == nil {
+		opts = new(Options)
 	}
+	return &Program{newLoader(opts).compile("", source)}, nil
+}
+
+// Parses a program given its source code and returns its syntax tree
+// including comments.
+func ParseFile(source string) (file *ast.File, err error) {
+	defer catchParseErr(&err)
+	return parseFile(source, true), nil
+}
+
+func parse(source string) function {
+	return gen(parseFile(source, false), nil, false, false)
+}
+
+func parseFile(source string, comments bool) *ast.File {
+	p := parser{l: newLexer(source, comments), nxt: make([]*lex, 0), lines: newLineTable(source)}
+	stmts, _ := p.stmts(lexEof)
+	return &ast.File{Stmts: stmts, Comments: p.comments}
+}
+
+// parses statements until endCriteria, returns them and the end lexeme
+func (p *parser) stmts(endCriteria lexType) ([]ast.Stmt, *lex) {
+	stmts := make([]ast.Stmt, 0)
 	for {
+		var s ast.Stmt
 		switch l := p.get(); l.typ {
 		case lexEos:
 			continue
 		case lexIf:
-			f = p.pIf(f)
+			s = p.pIf(l)
 		case lexFor:
-			f = p.pFor(f)
+			s = p.pFor(l)
 		case lexWhile:
-			f = p.pWhile(f)
+			s = p.pWhile(l)
+		case lexTry:
+			s = p.pTry(l)
+		case lexFunc:
+			s = p.pFunc(l)
+		case lexImport:
+			s = p.pImport(l)
+		case lexThrow:
+			lp := p.get()
+			if lp.typ != lexLeftPar {
+				p.parseErr("(", lp)
+			}
+			x := p.expr()
+			rp := p.get()
+			if rp.typ != lexRightPar {
+				p.parseErr(")", rp)
+			}
+			s = &ast.ThrowStmt{Throw: p.pos(l), Lparen: p.pos(lp), Value: x, Rparen: p.pos(rp)}
+		case lexOpen, lexRead, lexWrite, lexClose, lexReadLines:
+			s = &ast.BuiltinStmt{Call: p.builtinCall(l)}
 		case lexPrint:
-			var n int
-			f, n = p.callArgs(f)
-			f = append(f, op{opPrint, n})
+			fallthrough
 		case lexPrintLn:
-			var n int
-			f, n = p.callArgs(f)
-			f = append(f, op{opPrintLn, n})
+			lp, args, rp := p.callArgs()
+			s = &ast.PrintStmt{At: p.pos(l), NewLine: l.typ == lexPrintLn, Lparen: lp, Args: args, Rparen: rp}
 		case lexDot:
 			fallthrough
 		case lexName:
-			f = p.name(f, l)
+			name := p.name(l)
 			switch n := p.get(); n.typ {
 			case lexEq:
-				f = p.assign(f)
+				s = &ast.AssignStmt{Target: name, Value: p.assign()}
 			case lexLeftPar:
-				f = p.call(f)
+				s = &ast.CallStmt{Call: p.call(name, n)}
+			case lexComma:
+				s = p.multiAssign(name)
 			default:
-				parseErr("= or (", n)
+				p.parseErr("= or (", n)
 			}
 		case lexReturn:
+			ret := &ast.ReturnStmt{Return: p.pos(l)}
 			if nt := p.next(0).typ; nt != lexEos && nt != endCriteria {
-				f = p.expr(f)
-				f = append(f, op{opReturn, 1})
-			} else {
-				f = append(f, op{opReturn, 0})
+				ret.Values = p.exprs()
 			}
+			s = ret
 		case endCriteria:
-			return f
+			return stmts, l
 		default:
-			parseErr("if, for, while or name", l)
+			p.parseErr("if, for, while, try, throw, func, import or name", l)
 		}
+		stmts = append(stmts, s)
 		if l := p.get(); l.typ != lexEos && l.typ != endCriteria {
-			parseErr("; or newline", l)
+			p.parseErr("; or newline", l)
 		} else if l.typ == endCriteria {
-			return f
+			return stmts, l
 		}
 	}
-	return f
 }
 
-// already parsed name and =; name is on top of the stack
-func (p *parser) assign(f function) function {
+// parses { statements }
+func (p *parser) block() *ast.Block {
+	l := p.get()
+	if l.typ != lexLeftBrace {
+		p.parseErr("{", l)
+	}
+	stmts, end := p.stmts(lexRightBrace)
+	return &ast.Block{Lbrace: p.pos(l), Stmts: stmts, Rbrace: p.pos(end)}
+}
+
+// already parsed the first name and a comma; parses "a, b = x, y"
+func (p *parser) multiAssign(first *ast.Name) *ast.MultiAssignStmt {
+	s := &ast.MultiAssignStmt{Targets: []*ast.Name{first}}
+	for {
+		s.Targets = append(s.Targets, p.name(p.get()))
+		l := p.get()
+		if l.typ == lexEq {
+			break
+		}
+		if l.typ != lexComma {
+			p.parseErr(", or =", l)
+		}
+	}
+	pos := p.pos(p.next(0))
+	s.Values = p.exprs()
+	if len(s.Values) == 1 {
+		if _, ok := s.Values[0].(*ast.CallExpr); ok {
+			return s
+		}
+	}
+	if len(s.Values) != len(s.Targets) {
+		panic(parseError(fmt.Sprintf("Parse error: %v: assignment to %d variables needs a call or %d values, got %d.",
+			pos, len(s.Targets), len(s.Targets), len(s.Values))))
+	}
+	return s
+}
+
+// parses expr, expr...
+func (p *parser) exprs() []ast.Expr {
+	xs := []ast.Expr{p.expr()}
+	for p.next(0).typ == lexComma {
+		p.skip(1)
+		xs = append(xs, p.expr())
+	}
+	return xs
+}
+
+// parses func name(params) { }
+func (p *parser) pFunc(l *lex) *ast.FuncDecl {
+	n := p.get()
+	if n.typ != lexName {
+		p.parseErr("name", n)
+	}
+	if p.next(0).typ != lexLeftPar {
+		p.parseErr("(", p.next(0))
+	}
+	return &ast.FuncDecl{Func: p.pos(l), Name: &ast.Name{NamePos: p.pos(n), Name: n.val}, Fun: p.funLit()}
+}
+
+// parses import "path" as name
+func (p *parser) pImport(l *lex) *ast.ImportStmt {
+	path := p.get()
+	if path.typ != lexString {
+		p.parseErr("string", path)
+	}
+	if _, err := strconv.Unquote(path.val); err != nil {
+		p.parseErr("string", path)
+	}
+	as := p.get()
+	if as.typ != lexName || as.val != "as" {
+		p.parseErr("as", as)
+	}
+	n := p.get()
+	if n.typ != lexName {
+		p.parseErr("name", n)
+	}
+	return &ast.ImportStmt{
+		Import: p.pos(l),
+		Path:   &ast.BasicLit{ValuePos: p.pos(path), Kind: ast.String, Value: path.val},
+		As:     p.pos(as),
+		Name:   &ast.Name{NamePos: p.pos(n), Name: n.val},
+	}
+}
+
+// already parsed name and =; parses the value
+func (p *parser) assign() ast.Expr {
 	if p.next(0).typ == lexLeftPar {
 		switch p.next(1).typ {
 		case lexRightPar:
-			p.skip(2)
-			if p.next(0).typ != lexLeftBrace {
-				parseErr("{", p.next(0))
-			}
-			f = append(f, op{opFunction, p.fun(make(function, 0))})
+			return p.funLit()
 		case lexName:
-			if p.next(2).typ == lexComma || (p.next(2).typ == lexRightPar && p.next(3).typ == lexLeftBrace) {
-				f = append(f, op{opFunction, p.fun(p.funArgs())})
-			} else {
-				f = p.expr(f)
+			switch p.next(2).typ {
+			case lexComma, lexEq:
+				return p.funLit()
+			case lexDot: // (xs...), not (r.x)
+				if p.next(3).typ == lexDot && p.next(4).typ == lexDot {
+					return p.funLit()
+				}
+			case lexRightPar:
+				if p.next(3).typ == lexLeftBrace {
+					return p.funLit()
+				}
 			}
-		default:
-			f = p.expr(f)
 		}
-	} else {
-		f = p.expr(f)
 	}
-	f = append(f, op{opStoreStr, nil})
-	return f
+	return p.expr()
 }
 
-// parses (arg, arg, arg...) and retuns opStore for each arg
-func (p *parser) funArgs() function {
-	p.skip(1) // already know that we have opLeftPar here
-	ret := make(function, 0)
+// parses (arg, arg = default, args...) { }
+func (p *parser) funLit() *ast.FuncLit {
+	lp := p.get() // already know that we have lexLeftPar here
+	fun := &ast.FuncLit{Lparen: p.pos(lp), Params: make([]*ast.Name, 0)}
 	for arg := p.get(); arg.typ != lexRightPar; arg = p.get() {
+		if fun.Ellipsis.IsValid() {
+			p.parseErr(")", arg)
+		}
 		if arg.typ != lexName {
-			parseErr("name", arg)
+			p.parseErr("name", arg)
+		}
+		fun.Params = append(fun.Params, &ast.Name{NamePos: p.pos(arg), Name: arg.val})
+		var def ast.Expr
+		switch p.next(0).typ {
+		case lexEq:
+			p.skip(1)
+			def = p.expr()
+		case lexDot:
+			fun.Ellipsis = p.pos(p.next(0))
+			for i := 0; i < 3; i++ {
+				if l := p.get(); l.typ != lexDot {
+					p.parseErr("...", l)
+				}
+			}
+		default:
+			if fun.Defaults != nil { // only the last parameters may have defaults
+				p.parseErr("=", p.next(0))
+			}
+		}
+		if def != nil && fun.Defaults == nil {
+			fun.Defaults = make([]ast.Expr, len(fun.Params)-1)
+		}
+		if fun.Defaults != nil {
+			fun.Defaults = append(fun.Defaults, def)
 		}
-		ret = append(ret, op{opStore, arg.val})
 		if p.next(0).typ == lexComma {
 			p.skip(1)
 		}
 	}
-	return ret
+	fun.Body = p.block()
+	return fun
 }
 
-// parses (expr, expr, expr...), returns number of arguments
-func (p *parser) callArgs(f function) (function, int) {
-	var args int
-	if l := p.get(); l.typ != lexLeftPar {
-		parseErr("(", l)
+// parses (expr, expr, expr...)
+func (p *parser) callArgs() (lparen ast.Pos, args []ast.Expr, rparen ast.Pos) {
+	l := p.get()
+	if l.typ != lexLeftPar {
+		p.parseErr("(", l)
 	}
+	args = make([]ast.Expr, 0)
 	for p.next(0).typ != lexRightPar {
-		args++
-		f = p.expr(f)
+		args = append(args, p.expr())
 		if p.next(0).typ == lexComma {
 			p.skip(1)
 		} else if p.next(0).typ != lexRightPar {
-			parseErr(", or )", p.next(0))
+			p.parseErr(", or )", p.next(0))
 		}
 	}
-	p.skip(1)
-	return f, args
+	return p.pos(l), args, p.pos(p.get())
 }
 
+// precedence of binary operators is the same as in Go
 var levelLex = [...][]lexType{{lexOr}, {lexAnd}, {lexEqEq, lexNeq},
-	{lexLess, lexGreater, lexLeq, lexGeq}, {lexPlus, lexMinus},
-	{lexMul, lexDiv, lexMod}}
-var levelOp = [...][]opType{{opOr}, {opAnd}, {opEq, opNeq},
-	{opLess, opGreater, opLeq, opGeq}, {opSum, opSub},
-	{opMul, opDiv, opMod}}
+	{lexLess, lexGreater, lexLeq, lexGeq}, {lexPlus, lexMinus, lexBitOr, lexBitXor},
+	{lexMul, lexDiv, lexMod, lexBitAnd, lexShl, lexShr}}
 
-func (p *parser) expr0(f function, level int) function {
+func (p *parser) expr0(level int) ast.Expr {
 	if level < len(levelLex) {
-		f := p.expr0(f, level+1)
+		x := p.expr0(level + 1)
 		lex := levelLex[level]
-		opt := levelOp[level]
 		found := true
 		for found {
 			found = false
-			nt := p.next(0).typ
-			for i, l := range lex {
-				if l == nt {
+			nt := p.next(0)
+			for _, l := range lex {
+				if l == nt.typ {
 					p.skip(1)
-					f = p.expr0(f, level+1)
-					f = append(f, op{opt[i], nil})
+					x = &ast.BinaryExpr{X: x, OpPos: p.pos(nt), Op: nt.val, Y: p.expr0(level + 1)}
 					found = true
 					break
 				}
 			}
 		}
-		return f
+		return x
 	}
 	switch l := p.get(); l.typ {
-	case lexMinus:
-		f = p.expr0(f, level)
-		f = append(f, op{opNeg, nil})
-	case lexNot:
-		f = p.expr0(f, level)
-		f = append(f, op{opNot, nil})
+	case lexMinus, lexNot, lexBitNot:
+		return &ast.UnaryExpr{OpPos: p.pos(l), Op: l.val, X: p.expr0(level)}
 	case lexLeftPar:
-		f = p.expr(f)
-		if n := p.get(); n.typ != lexRightPar {
-			parseErr(")", n)
+		x := p.expr()
+		n := p.get()
+		if n.typ != lexRightPar {
+			p.parseErr(")", n)
 		}
+		return &ast.ParenExpr{Lparen: p.pos(l), X: x, Rparen: p.pos(n)}
 	case lexInt:
-		i, err := strconv.ParseInt(l.val, 10, 64)
-		if err != nil {
-			parseErr("int", l)
+		if _, err := intValue(l.val); err != nil {
+			p.parseErr("int", l)
 		}
-		f = append(f, op{opInt, i})
+		return &ast.BasicLit{ValuePos: p.pos(l), Kind: ast.Int, Value: l.val}
 	case lexFloat:
-		fl, err := strconv.ParseFloat(l.val, 64)
-		if err != nil {
-			parseErr("float", l)
+		if _, err := floatValue(l.val); err != nil {
+			p.parseErr("float", l)
 		}
-		f = append(f, op{opFloat, fl})
+		return &ast.BasicLit{ValuePos: p.pos(l), Kind: ast.Float, Value: l.val}
 	case lexBool:
-		b, err := strconv.ParseBool(l.val)
-		if err != nil {
-			parseErr("bool", l)
+		if _, err := strconv.ParseBool(l.val); err != nil {
+			p.parseErr("bool", l)
 		}
-		f = append(f, op{opBool, b})
+		return &ast.BasicLit{ValuePos: p.pos(l), Kind: ast.Bool, Value: l.val}
 	case lexString:
-		s, err := strconv.Unquote(l.val)
-		if err != nil {
-			parseErr("string", l)
-		}
-		f = append(f, op{opString, s})
-	case lexReadInt:
-		f = append(f, op{opReadInt, nil})
-	case lexReadFloat:
-		f = append(f, op{opReadFloat, nil})
-	case lexReadLine:
-		f = append(f, op{opReadLine, nil})
-	case lexReadChar:
-		f = append(f, op{opReadChar, nil})
-	case lexRnd:
-		f = append(f, op{opRnd, nil})
+		if _, err := strconv.Unquote(l.val); err != nil {
+			p.parseErr("string", l)
+		}
+		return &ast.BasicLit{ValuePos: p.pos(l), Kind: ast.String, Value: l.val}
+	case lexNil:
+		return &ast.BasicLit{ValuePos: p.pos(l), Kind: ast.Nil, Value: l.val}
+	case lexLeftBrace:
+		return p.record(l)
+	case lexReadInt, lexReadFloat, lexReadLine, lexReadChar, lexRnd:
+		return &ast.Builtin{At: p.pos(l), Name: l.val}
+	case lexTypeOf, lexDefined, lexToInt, lexToFloat, lexToStr, lexToBool, lexRange,
+		lexOpen, lexRead, lexWrite, lexClose, lexReadLines:
+		return p.builtinCall(l)
 	case lexDot:
 		fallthrough
 	case lexName:
-		f = p.name(f, l)
-		f = append(f, op{opLoadStr, nil})
+		name := p.name(l)
 		if p.next(0).typ == lexLeftPar { // function call
-			var num int
-			f, num = p.callArgs(f)
-			f = append(f, op{opCall, num})
+			lp, args, rp := p.callArgs()
+			return &ast.CallExpr{Fun: name, Lparen: lp, Args: args, Rparen: rp}
 		}
+		return name
 	default:
-		parseErr("int, float, string, bool, name or call", l)
+		p.parseErr("int, float, string, bool, nil, name or call", l)
+	}
+	return nil
+}
+
+// minimal and maximal number of parameters of builtin functions
+var builtinParams = map[lexType][2]int{
+	lexTypeOf:    {1, 1},
+	lexDefined:   {1, 1},
+	lexToInt:     {1, 1},
+	lexToFloat:   {1, 1},
+	lexToStr:     {1, 1},
+	lexToBool:    {1, 1},
+	lexRange:     {1, 3},
+	lexOpen:      {1, 2},
+	lexRead:      {1, 1},
+	lexWrite:     {2, 2},
+	lexClose:     {1, 1},
+	lexReadLines: {1, 1},
+}
+
+// already parsed {; parses fields of a record literal, which may be on
+// separate lines
+func (p *parser) record(l *lex) *ast.RecordLit {
+	x := &ast.RecordLit{Lbrace: p.pos(l), Fields: make([]*ast.Field, 0)}
+	for {
+		n := p.getSkipEos()
+		if n.typ == lexRightBrace {
+			x.Rbrace = p.pos(n)
+			return x
+		}
+		if n.typ != lexName {
+			p.parseErr("field name or }", n)
+		}
+		if c := p.get(); c.typ != lexColon {
+			p.parseErr(":", c)
+		}
+		x.Fields = append(x.Fields, &ast.Field{NamePos: p.pos(n), Name: n.val, Value: p.expr()})
+		if n = p.getSkipEos(); n.typ == lexRightBrace {
+			x.Rbrace = p.pos(n)
+			return x
+		}
+		if n.typ != lexComma {
+			p.parseErr(", or }", n)
+		}
 	}
-	return f
 }
 
-func (p *parser) name(f function, l *lex) function {
-	n := l.val
+// returns the next lexeme which is not a newline or ;
+func (p *parser) getSkipEos() *lex {
+	l := p.get()
+	for l.typ == lexEos {
+		l = p.get()
+	}
+	return l
+}
+
+// already parsed the name of a builtin function
+func (p *parser) builtinCall(l *lex) *ast.BuiltinCall {
+	x := &ast.BuiltinCall{NamePos: p.pos(l), Name: l.val}
+	lp := p.get()
+	if lp.typ != lexLeftPar {
+		p.parseErr("(", lp)
+	}
+	x.Lparen = p.pos(lp)
+	params := builtinParams[l.typ]
+	for i := 0; i < params[1]; i++ {
+		if i >= params[0] && p.next(0).typ == lexRightPar {
+			break
+		}
+		if i > 0 {
+			if c := p.get(); c.typ != lexComma {
+				p.parseErr(",", c)
+			}
+		}
+		if l.typ == lexDefined {
+			n := p.name(p.get())
+			if len(n.Fields) > 0 {
+				panic(parseError(fmt.Sprintf("Parse error: %v: defined takes a variable, not a field.", n.Pos())))
+			}
+			x.Args = append(x.Args, n)
+		} else {
+			x.Args = append(x.Args, p.expr())
+		}
+	}
+	rp := p.get()
+	if rp.typ != lexRightPar {
+		p.parseErr(")", rp)
+	}
+	x.Rparen = p.pos(rp)
+	return x
+}
+
+func (p *parser) name(l *lex) *ast.Name {
+	n := &ast.Name{NamePos: p.pos(l)}
 	for l.typ == lexDot {
+		n.Dots++
 		l = p.get()
-		n += l.val
 	}
 	if l.typ != lexName {
-		parseErr("name or .", l)
+		p.parseErr("name or .", l)
 	}
-	f = append(f, op{opString, n})
+	n.Name = l.val
 	for p.next(0).typ == lexLeftBracket {
 		p.skip(1)
-		f = append(f, op{opString, "["})
-		f = append(f, op{opSum, nil})
-		f = p.expr(f)
-		f = append(f, op{opSum, nil})
+		n.Index = append(n.Index, p.expr())
 		if n := p.get(); n.typ != lexRightBracket {
-			parseErr("[", n)
+			p.parseErr("[", n)
 		}
-		f = append(f, op{opString, "]"})
-		f = append(f, op{opSum, nil})
 	}
-	return f
+	// dots after the identifier can not be scope dots of another name
+	for p.next(0).typ == lexDot && p.next(1).typ == lexName {
+		n.Fields = append(n.Fields, p.next(1).val)
+		p.skip(2)
+	}
+	return n
 }
 
-func (p *parser) expr(f function) function {
-	return p.expr0(f, 0)
+// parses an expression; the conditional operator has the lowest precedence
+func (p *parser) expr() ast.Expr {
+	x := p.expr0(0)
+	q := p.next(0)
+	if q.typ != lexQuestion {
+		return x
+	}
+	p.skip(1)
+	then := p.expr()
+	c := p.get()
+	if c.typ != lexColon {
+		p.parseErr(":", c)
+	}
+	return &ast.CondExpr{Cond: x, Question: p.pos(q), X: then, Colon: p.pos(c), Y: p.expr()}
 }
 
-// already parsed name and (; name is a string in the stack
-func (p *parser) call(f function) function {
-	f = append(f, op{opLoadStr, nil})
-	var args int
-	for args = 0; p.next(0).typ != lexRightPar; args++ {
-		f = p.expr(f)
+// already parsed name and (
+func (p *parser) call(name *ast.Name, lparen *lex) *ast.CallExpr {
+	args := make([]ast.Expr, 0)
+	for p.next(0).typ != lexRightPar {
+		args = append(args, p.expr())
 		if p.next(0).typ == lexComma {
 			p.skip(1)
 		}
 	}
-	p.skip(1) // )
-	f = append(f, op{opCall, args})
-	f = append(f, op{opPop, nil}) // ignore return value
-	return f
+	return &ast.CallExpr{Fun: name, Lparen: p.pos(lparen), Args: args, Rparen: p.pos(p.get())}
 }
 
-func (p *parser) pIf(f function) function {
-	f = p.expr(f)
+func (p *parser) pIf(l *lex) *ast.IfStmt {
+	s := &ast.IfStmt{If: p.pos(l), Cond: p.expr()}
 	if p.next(0).typ != lexLeftBrace {
-		parseErr("{", p.next(0))
+		p.parseErr("{", p.next(0))
 	}
-	body := p.fun(make(function, 0))
-	elseBody := make(function, 0)
+	s.Body = p.block()
 	if p.next(0).typ == lexElse {
 		p.skip(1)
 		if p.next(0).typ != lexLeftBrace {
-			parseErr("{", p.next(0))
+			p.parseErr("{", p.next(0))
 		}
-		elseBody = p.fun(elseBody)
-		body = append(body, op{opJmp, len(elseBody) + 1})
+		s.Else = p.block()
 	}
-	f = append(f, op{opJmpFalse, len(body) + 1})
-	f = append(f, body...)
-	f = append(f, elseBody...)
-	return f
+	return s
 }
 
-func (p *parser) assignOrNone(f function) function {
+func (p *parser) assignOrNone() *ast.AssignStmt {
 	if l := p.next(0); l.val != ";" {
-		f = p.name(f, p.get())
+		name := p.name(p.get())
 		if n := p.get(); n.typ != lexEq {
-			parseErr("=", n)
+			p.parseErr("=", n)
 		}
-		f = p.assign(f)
+		return &ast.AssignStmt{Target: name, Value: p.assign()}
 	}
-	return f
+	return nil
 }
 
 func (p *parser) semicolon() {
 	if l := p.get(); l.val != ";" {
-		parseErr(";", l)
+		p.parseErr(";", l)
 	}
 }
 
-func (p *parser) pFor(f function) function {
-	f = p.assignOrNone(f)
+func (p *parser) pFor(l *lex) ast.Stmt {
+	if p.next(0).typ == lexName && p.next(1).typ == lexName && p.next(1).val == "in" {
+		s := &ast.ForInStmt{For: p.pos(l), Var: p.name(p.get()), In: p.pos(p.get())}
+		s.X = p.expr()
+		if p.next(0).typ != lexLeftBrace {
+			p.parseErr("{", p.next(0))
+		}
+		s.Body = p.block()
+		return s
+	}
+	s := &ast.ForStmt{For: p.pos(l), Init: p.assignOrNone()}
 	p.semicolon()
-	start := len(f)
-	f = p.expr(f)
+	s.Cond = p.expr()
 	p.semicolon()
-	after := p.assignOrNone(make(function, 0))
+	s.Post = p.assignOrNone()
+	if p.next(0).typ != lexLeftBrace {
+		p.parseErr("{", p.next(0))
+	}
+	s.Body = p.block()
+	return s
+}
+
+func (p *parser) pWhile(l *lex) *ast.WhileStmt {
+	s := &ast.WhileStmt{While: p.pos(l), Cond: p.expr()}
 	if p.next(0).typ != lexLeftBrace {
-		parseErr("{", p.next(0))
+		p.parseErr("{", p.next(0))
 	}
-	body := p.fun(make(function, 0))
-	f = append(f, op{opJmpFalse, len(body) + len(after) + 2})
-	f = append(f, body...)
-	f = append(f, after...)
-	f = append(f, op{opJmp, start - len(f)})
-	return f
+	s.Body = p.block()
+	return s
 }
 
-func (p *parser) pWhile(f function) function {
-	start := len(f)
-	f = p.expr(f)
+func (p *parser) pTry(l *lex) *ast.TryStmt {
 	if p.next(0).typ != lexLeftBrace {
-		parseErr("{", p.next(0))
+		p.parseErr("{", p.next(0))
+	}
+	s := &ast.TryStmt{Try: p.pos(l), Body: p.block()}
+	if p.next(0).typ == lexCatch {
+		p.skip(1)
+		if p.next(0).typ == lexLeftPar {
+			p.skip(1)
+			if n := p.get(); n.typ != lexName {
+				p.parseErr("name", n)
+			} else {
+				s.Param = &ast.Name{NamePos: p.pos(n), Name: n.val}
+			}
+			if rp := p.get(); rp.typ != lexRightPar {
+				p.parseErr(")", rp)
+			}
+		}
+		if p.next(0).typ != lexLeftBrace {
+			p.parseErr("{", p.next(0))
+		}
+		s.Catch = p.block()
 	}
-	body := p.fun(make(function, 0))
-	f = append(f, op{opJmpFalse, len(body) + 2})
-	f = append(f, body...)
-	f = append(f, op{opJmp, start - len(f)})
-	return f
+	if p.next(0).typ == lexFinally {
+		p.skip(1)
+		if p.next(0).typ != lexLeftBrace {
+			p.parseErr("{", p.next(0))
+		}
+		s.Finally = p.block()
+	}
+	if s.Catch == nil && s.Finally == nil {
+		p.parseErr("catch or finally", p.next(0))
+	}
+	return s
 }
 
-func parseErr(expected string, got *lex) {
-	fmt.Printf("Parse error: expected %s, got %#v.\n", expected, *got)
-	os.Exit(1)
+type parseError string
+
+func (e parseError) Error() string {
+	return string(e)
+}
+
+func (p *parser) parseErr(expected string, got *lex) {
+	panic(parseError(fmt.Sprintf("Parse error: %v: expected %s, got %q.", p.pos(got), expected, got.val)))
+}
+
+func catchParseErr(err *error) {
+	if r := recover(); r != nil {
+		pe, ok := r.(parseError)
+		if !ok {
+			panic(r)
+		}
+		*err = pe
+	}
+}
+
+// pos converts position of l to line and column
+func (p *parser) pos(l *lex) ast.Pos {
+	return p.lines.pos(l.pos)
+}
+
+// byte offsets of line beginnings
+type lineTable []int
+
+func newLineTable(source string) lineTable {
+	lines := lineTable{0}
+	for i, c := range source {
+		if c == '\n' {
+			lines = append(lines, i+1)
+		}
+	}
+	return lines
+}
+
+// converts a byte offset to line and column
+func (lines lineTable) pos(offset int) ast.Pos {
+	line := sort.Search(len(lines), func(i int) bool { return lines[i] > offset })
+	return ast.Pos{Line: line, Col: offset - lines[line-1] + 1}
 }
 
 func (p *parser) get() *lex {
 	if len(p.nxt) == 0 {
-		return p.l.get()
+		return p.lex()
 	}
 	ret := p.nxt[0]
 	p.nxt = p.nxt[1:]
@@ -357,11 +665,24 @@ func (p *parser) get() *lex {
 
 func (p *parser) next(i int) *lex {
 	for len(p.nxt) <= i {
-		p.nxt = append(p.nxt, p.l.get())
+		p.nxt = append(p.nxt, p.lex())
 	}
 	return p.nxt[i]
 }
 
+// gets a lexeme from the lexer, collecting comments on the way
+func (p *parser) lex() *lex {
+	l := p.l.get()
+	for l.typ == lexComment {
+		p.comments = append(p.comments, &ast.Comment{Slash: p.pos(l), Text: l.val})
+		l = p.l.get()
+	}
+	if l.typ == lexError {
+		panic(parseError(fmt.Sprintf("Parse error: %v: %s.", p.pos(l), l.err)))
+	}
+	return l
+}
+
 func (p *parser) skip(x int) {
 	p.nxt = p.nxt[x:]
 }
@@ -1,6 +1,11 @@
 package yail
 
-import "testing"
+import (
+	"reflect"
+	"testing"
+
+	"github.com/mabu/yail/ast"
+)
 
 func TestEmpty(t *testing.T) {
 	runParseTest(t, "", function{})
@@ -11,7 +16,7 @@ func TestAssign(t *testing.T) {
 }
 
 func TestCall(t *testing.T) {
-	runParseTest(t, "blac()", function{op{opString, "blac"}, op{opLoadStr, nil}, op{opCall, int(0)}, op{opPop, nil}})
+	runParseTest(t, "blac()", function{op{opString, "blac"}, op{opLoadStr, nil}, op{opCallMulti, int(0)}, op{opPop, nil}})
 }
 
 func TestParseName(t *testing.T) {
@@ -50,6 +55,117 @@ func TestNumExpr(t *testing.T) {
 		op{opStoreStr, nil}})
 }
 
+func TestNumberLiteral(t *testing.T) {
+	runParseTest(t, "a = 0xff + 0b101 + 0o17 + 1_000 + 1e-3 + .25", function{
+		op{opString, "a"},
+		op{opInt, int64(255)},
+		op{opInt, int64(5)},
+		op{opSum, nil},
+		op{opInt, int64(15)},
+		op{opSum, nil},
+		op{opInt, int64(1000)},
+		op{opSum, nil},
+		op{opFloat, 0.001},
+		op{opSum, nil},
+		op{opFloat, 0.25},
+		op{opSum, nil},
+		op{opStoreStr, nil}})
+}
+
+func TestBitwiseExpr(t *testing.T) {
+	runParseTest(t, "a = ~b | c & 1 << 2 == 0", function{
+		op{opString, "a"},
+		op{opString, "b"},
+		op{opLoadStr, nil},
+		op{opBitNot, nil},
+		op{opString, "c"},
+		op{opLoadStr, nil},
+		op{opInt, int64(1)},
+		op{opBitAnd, nil},
+		op{opInt, int64(2)},
+		op{opShl, nil},
+		op{opBitOr, nil},
+		op{opInt, int64(0)},
+		op{opEq, nil},
+		op{opStoreStr, nil}})
+}
+
+func TestCondExpr(t *testing.T) {
+	runParseTest(t, "a = b ? 1 : c ? 2 : 3", function{
+		op{opString, "a"},
+		op{opString, "b"},
+		op{opLoadStr, nil},
+		op{opJmpFalse, 3},
+		op{opInt, int64(1)},
+		op{opJmp, 7},
+		op{opString, "c"},
+		op{opLoadStr, nil},
+		op{opJmpFalse, 3},
+		op{opInt, int64(2)},
+		op{opJmp, 2},
+		op{opInt, int64(3)},
+		op{opStoreStr, nil}})
+}
+
+func TestBuiltinCall(t *testing.T) {
+	runParseTest(t, "a = type(nil) == \"nil\" && defined(.b[1])", function{
+		op{opString, "a"},
+		op{opNil, nil},
+		op{opTypeOf, nil},
+		op{opString, "nil"},
+		op{opEq, nil},
+		op{opString, ".b"},
+		op{opString, "["},
+		op{opSum, nil},
+		op{opInt, int64(1)},
+		op{opSum, nil},
+		op{opString, "]"},
+		op{opSum, nil},
+		op{opDefined, nil},
+		op{opAnd, nil},
+		op{opStoreStr, nil}})
+}
+
+func TestTry(t *testing.T) {
+	runParseTest(t, `f = () {
+		try { a = 1; return a } catch (e) { throw(e) } finally { b = 2 }
+	}`, function{
+		op{opString, "f"},
+		op{opFunction, function{
+			op{opArgs, arity{0, 0}},
+			op{opTry, 16},
+			op{opString, "a"},
+			op{opInt, int64(1)},
+			op{opStoreStr, nil},
+			op{opString, "a"},
+			op{opLoadStr, nil},
+			op{opEndTry, 0},
+			op{opString, "b"},
+			op{opInt, int64(2)},
+			op{opStoreStr, nil},
+			op{opReturn, 1},
+			op{opEndTry, 0},
+			op{opString, "b"},
+			op{opInt, int64(2)},
+			op{opStoreStr, nil},
+			op{opJmp, 15},
+			op{opTry, 10},
+			op{opStore, "e"},
+			op{opString, "e"},
+			op{opLoadStr, nil},
+			op{opThrow, nil},
+			op{opEndTry, 0},
+			op{opString, "b"},
+			op{opInt, int64(2)},
+			op{opStoreStr, nil},
+			op{opJmp, 5},
+			op{opString, "b"},
+			op{opInt, int64(2)},
+			op{opStoreStr, nil},
+			op{opThrow, nil}}},
+		op{opStoreStr, nil}})
+}
+
 func TestBoolExpr(t *testing.T) {
 	f := function{op{opString, "a"},
 		op{opString, "b"},
@@ -153,6 +269,7 @@ func TestFunctions(t *testing.T) {
 	@print(fun(5))`, function{
 		op{opString, "fun"},
 		op{opFunction, function{
+			op{opArgs, arity{1, 1}},
 			op{opStore, "x"},
 			op{opString, "x"},
 			op{opLoadStr, nil},
@@ -183,6 +300,162 @@ func TestFunctions(t *testing.T) {
 	})
 }
 
+func TestFuncDecl(t *testing.T) {
+	runParseTest(t, "a = f(1)\nfunc f(x) { return x }", function{
+		op{opFunction, function{
+			op{opArgs, arity{1, 1}},
+			op{opStore, "x"},
+			op{opString, "x"},
+			op{opLoadStr, nil},
+			op{opReturn, 1}}},
+		op{opDeclare, "f"},
+		op{opString, "a"},
+		op{opString, "f"},
+		op{opLoadStr, nil},
+		op{opInt, int64(1)},
+		op{opCall, 1},
+		op{opStoreStr, nil}})
+}
+
+func TestParams(t *testing.T) {
+	runParseTest(t, "f = (a, b = 2, c...) {}", function{
+		op{opString, "f"},
+		op{opFunction, function{
+			op{opArgs, arity{1, -1}},
+			op{opStore, "a"},
+			op{opJmpArg, 2},
+			op{opInt, int64(2)},
+			op{opStore, "b"},
+			op{opRest, "c"}}},
+		op{opStoreStr, nil}})
+}
+
+func TestMultiAssign(t *testing.T) {
+	runParseTest(t, "a, b[1] = b[1], a\nc, d = f()", function{
+		op{opString, "b"},
+		op{opString, "["},
+		op{opSum, nil},
+		op{opInt, int64(1)},
+		op{opSum, nil},
+		op{opString, "]"},
+		op{opSum, nil},
+		op{opLoadStr, nil},
+		op{opString, "a"},
+		op{opLoadStr, nil},
+		op{opPack, 2},
+		op{opUnpack, 2},
+		op{opStore, "a"},
+		op{opString, "b"},
+		op{opString, "["},
+		op{opSum, nil},
+		op{opInt, int64(1)},
+		op{opSum, nil},
+		op{opString, "]"},
+		op{opSum, nil},
+		op{opSwap, nil},
+		op{opStoreStr, nil},
+		op{opString, "f"},
+		op{opLoadStr, nil},
+		op{opCallMulti, 0},
+		op{opUnpack, 2},
+		op{opStore, "c"},
+		op{opStore, "d"}})
+}
+
+func TestForIn(t *testing.T) {
+	runParseTest(t, "for i in range(1, n) { @print(i) }", function{
+		op{opInt, int64(1)},
+		op{opString, "n"},
+		op{opLoadStr, nil},
+		op{opRange, 2},
+		op{opIter, nil},
+		op{opNext, 6},
+		op{opStore, "i"},
+		op{opString, "i"},
+		op{opLoadStr, nil},
+		op{opPrint, 1},
+		op{opJmp, -5}})
+}
+
+func TestRecord(t *testing.T) {
+	runParseTest(t, "p = {x: 1, pos: {}}\np.pos.y = p.x", function{
+		op{opString, "p"},
+		op{opString, "x"},
+		op{opInt, int64(1)},
+		op{opString, "pos"},
+		op{opRecord, 0},
+		op{opRecord, 2},
+		op{opStoreStr, nil},
+		op{opString, "p"},
+		op{opLoadStr, nil},
+		op{opField, "pos"},
+		op{opString, "p"},
+		op{opLoadStr, nil},
+		op{opField, "x"},
+		op{opSetField, "y"}})
+}
+
+func TestParseFile(t *testing.T) {
+	file, err := ParseFile("a = 1\nif a < 2 {\n\t@println(.b[a], f(a))\n}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(file.Stmts) != 2 {
+		t.Fatalf("Got %d statements, expected 2.", len(file.Stmts))
+	}
+	s, ok := file.Stmts[1].(*ast.IfStmt)
+	if !ok {
+		t.Fatalf("Got %#v, expected if statement.", file.Stmts[1])
+	}
+	print := s.Body.Stmts[0].(*ast.PrintStmt)
+	name := print.Args[0].(*ast.Name)
+	call := print.Args[1].(*ast.CallExpr)
+	for _, c := range []struct {
+		got, expect ast.Pos
+	}{
+		{s.If, ast.Pos{Line: 2, Col: 1}},
+		{s.Cond.(*ast.BinaryExpr).OpPos, ast.Pos{Line: 2, Col: 6}},
+		{s.Body.Rbrace, ast.Pos{Line: 4, Col: 1}},
+		{print.At, ast.Pos{Line: 3, Col: 2}},
+		{name.Pos(), ast.Pos{Line: 3, Col: 11}},
+		{call.Lparen, ast.Pos{Line: 3, Col: 19}},
+	} {
+		if c.got != c.expect {
+			t.Errorf("Got position %v, expected %v.", c.got, c.expect)
+		}
+	}
+	if name.Dots != 1 || name.Name != "b" || len(name.Index) != 1 {
+		t.Errorf("Got name %#v, expected .b[a].", name)
+	}
+}
+
+func TestParseError(t *testing.T) {
+	if _, err := ParseFile("a = 1\nb + 2"); err == nil || err.Error() != `Parse error: 2:3: expected = or (, got "+".` {
+		t.Errorf("Got error %v.", err)
+	}
+	if _, err := ParseFile("a = 0b12"); err == nil || err.Error() != `Parse error: 1:5: invalid digit '2' in binary literal.` {
+		t.Errorf("Got error %v.", err)
+	}
+	if _, err := ParseFile("f = (a = 1, b) {}"); err == nil || err.Error() != `Parse error: 1:14: expected =, got ")".` {
+		t.Errorf("Got error %v.", err)
+	}
+	if _, err := ParseFile("f = (a..., b) {}"); err == nil || err.Error() != `Parse error: 1:12: expected ), got "b".` {
+		t.Errorf("Got error %v.", err)
+	}
+	if _, err := ParseFile("a, b = 1, 2, 3"); err == nil || err.Error() != `Parse error: 1:8: assignment to 2 variables needs a call or 2 values, got 3.` {
+		t.Errorf("Got error %v.", err)
+	}
+	if _, err := ParseFile("p = {x: 1 y: 2}"); err == nil || err.Error() != `Parse error: 1:11: expected , or }, got "y".` {
+		t.Errorf("Got error %v.", err)
+	}
+	if _, err := ParseFile(`import "m.yail" m`); err == nil || err.Error() != `Parse error: 1:17: expected as, got "m".` {
+		t.Errorf("Got error %v.", err)
+	}
+	if _, err := ParseFile("func .f() {}"); err == nil || err.Error() != `Parse error: 1:6: expected name, got ".".` {
+		t.Errorf("Got error %v.", err)
+	}
+}
+
 func runParseTest(t *testing.T, source string, expect function) {
 	testFun(t, parse(source), expect)
 }
@@ -200,6 +473,12 @@ func testFun(t *testing.T, f, expect function) {
 			testFun(t, f[i].param.(function), op.param.(function))
 			continue
 		}
+		if op.typ == opFrame {
+			if f[i].typ != opFrame || !reflect.DeepEqual(f[i].param.(*frame).names, op.param.(*frame).names) {
+				t.Errorf("On position %d got %#v, expected %#v.", i, f[i], op)
+			}
+			continue
+		}
 		if op != f[i] {
 			t.Errorf("On position %d got %#v, expected %#v.", i, f[i], op)
 		}
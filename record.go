@@ -0,0 +1,82 @@
+package yail
+
+// This file contains records: values with named fields, e.g.
+// {name: "x", hp: 10}. Records are passed by reference.
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type record struct {
+	keys   []string // in order of creation
+	fields map[string]interface{}
+}
+
+func newRecord() *record {
+	return &record{fields: make(map[string]interface{})}
+}
+
+func (r *record) String() string {
+	return r.format(make(map[*record]bool))
+}
+
+// formats a record; the ones containing it are printed as {...}
+func (r *record) format(outer map[*record]bool) string {
+	if outer[r] {
+		return "{...}"
+	}
+	outer[r] = true
+	defer delete(outer, r)
+	fields := make([]string, len(r.keys))
+	for k, key := range r.keys {
+		switch v := r.fields[key].(type) {
+		case string:
+			fields[k] = key + ": " + strconv.Quote(v)
+		case nil:
+			fields[k] = key + ": nil"
+		case *RuntimeError:
+			fields[k] = key + ": " + v.text()
+		case *record:
+			fields[k] = key + ": " + v.format(outer)
+		default:
+			fields[k] = key + ": " + fmt.Sprint(v)
+		}
+	}
+	return "{" + strings.Join(fields, ", ") + "}"
+}
+
+func (r *record) get(key string) (interface{}, bool) {
+	val, ok := r.fields[key]
+	return val, ok
+}
+
+func (r *record) set(key string, val interface{}) {
+	if _, ok := r.fields[key]; !ok {
+		r.keys = append(r.keys, key)
+	}
+	r.fields[key] = val
+}
+
+func getRecord(v interface{}, err string) *record {
+	r, ok := v.(*record)
+	if !ok {
+		runtimeErr(err + ": " + typeName(v) + " is not a record")
+	}
+	return r
+}
+
+// iterates over names of the fields existing when the iteration started
+type keyIterator struct {
+	keys []string
+}
+
+func (it *keyIterator) next() (interface{}, bool) {
+	if len(it.keys) == 0 {
+		return nil, false
+	}
+	key := it.keys[0]
+	it.keys = it.keys[1:]
+	return key, true
+}
@@ -0,0 +1,51 @@
+package yail
+
+// This file contains a resolver which assigns slots to local variables, so
+// that they can be accessed by index instead of a map lookup. Only names
+// without dots and indices are known at compile time, other variables are
+// still kept in a map.
+
+import "github.com/mabu/yail/ast"
+
+// local variables of a function
+type frame struct {
+	names []string       // names of variables by slot
+	index map[string]int // slot of a variable
+}
+
+func newFrame(names []string) *frame {
+	fr := &frame{names, make(map[string]int, len(names))}
+	for k, name := range names {
+		fr.index[name] = k
+	}
+	return fr
+}
+
+// assigns slots to the local variables of a function in order of appearance
+func resolve(params []*ast.Name, stmts []ast.Stmt) *frame {
+	names := make([]string, 0)
+	seen := make(map[string]bool)
+	visit := func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.FuncLit: // has its own variables
+			return false
+		case *ast.Name:
+			if isLocal(n) && !seen[n.Name] {
+				seen[n.Name] = true
+				names = append(names, n.Name)
+			}
+		}
+		return true
+	}
+	for _, p := range params {
+		ast.Inspect(p, visit)
+	}
+	for _, s := range stmts {
+		ast.Inspect(s, visit)
+	}
+	return newFrame(names)
+}
+
+func isLocal(n *ast.Name) bool {
+	return n.Dots == 0 && len(n.Index) == 0
+}
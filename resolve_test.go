@@ -0,0 +1,91 @@
+package yail
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	testFun(t, gen(parseFile(`fun = (x) {
+		fun = .fun
+		y[x] = x
+		return x * fun(x - 1)
+	}
+	@print(fun(5))`, false), nil, true, false), function{
+		op{opFrame, newFrame([]string{"fun"})},
+		op{opFunction, function{
+			op{opFrame, newFrame([]string{"x", "fun"})},
+			op{opArgs, arity{1, 1}},
+			op{opStoreSlot, 0},
+			op{opString, ".fun"},
+			op{opLoadStr, nil},
+			op{opStoreSlot, 1},
+			op{opString, "y"},
+			op{opString, "["},
+			op{opSum, nil},
+			op{opLoadSlot, 0},
+			op{opSum, nil},
+			op{opString, "]"},
+			op{opSum, nil},
+			op{opLoadSlot, 0},
+			op{opStoreStr, nil},
+			op{opLoadSlot, 0},
+			op{opLoadSlot, 1},
+			op{opLoadSlot, 0},
+			op{opInt, int64(1)},
+			op{opSub, nil},
+			op{opCall, 1},
+			op{opMul, nil},
+			op{opReturn, 1},
+		}},
+		op{opStoreSlot, 0},
+		op{opLoadSlot, 0},
+		op{opInt, int64(5)},
+		op{opCall, 1},
+		op{opPrint, 1},
+	})
+}
+
+func TestResolveEmpty(t *testing.T) {
+	testFun(t, gen(parseFile(`@println(.a, b[1])`, false), nil, true, false), function{
+		op{opString, ".a"},
+		op{opLoadStr, nil},
+		op{opString, "b"},
+		op{opString, "["},
+		op{opSum, nil},
+		op{opInt, int64(1)},
+		op{opSum, nil},
+		op{opString, "]"},
+		op{opSum, nil},
+		op{opLoadStr, nil},
+		op{opPrintLn, 2},
+	})
+}
+
+const hotLoop = `sum = 0
+for i = 0; i < 100000; i = i + 1 {
+	x = i * 3 % 7
+	sum = sum + x
+}
+f = (n) {
+	for j = 0; j < n; j = j + 1 {
+		.sum = .sum - 1
+	}
+}
+f(1000)`
+
+func BenchmarkMapVariables(b *testing.B) {
+	benchmarkVariables(b, false)
+}
+
+func BenchmarkSlotVariables(b *testing.B) {
+	benchmarkVariables(b, true)
+}
+
+func benchmarkVariables(b *testing.B, slots bool) {
+	f := optimize(gen(parseFile(hotLoop, false), nil, slots, false))
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		newInterpreter(f, nil, ioutil.Discard).run()
+	}
+}
@@ -0,0 +1,98 @@
+package yail
+
+// This file exports the lexical analyser for tools like syntax highlighters.
+
+import "github.com/mabu/yail/ast"
+
+// TokenKind is a category of a Token.
+type TokenKind int
+
+const (
+	TokenError    TokenKind = iota // invalid input
+	TokenEOF                       // end of input
+	TokenComment                   // // or /* */ comment
+	TokenEOS                       // end of statement: ; or newline
+	TokenOperator                  // operator or punctuation, e.g. + or (
+	TokenKeyword                   // e.g. if or return
+	TokenBuiltin                   // @ function, e.g. @println
+	TokenName
+	TokenInt
+	TokenFloat
+	TokenString
+	TokenBool
+	TokenNil
+)
+
+var tokenKinds = map[lexType]TokenKind{
+	lexError:      TokenError,
+	lexEof:        TokenEOF,
+	lexComment:    TokenComment,
+	lexEos:        TokenEOS,
+	lexIf:         TokenKeyword,
+	lexElse:       TokenKeyword,
+	lexFor:        TokenKeyword,
+	lexWhile:      TokenKeyword,
+	lexReturn:     TokenKeyword,
+	lexReadInt:    TokenBuiltin,
+	lexReadFloat:  TokenBuiltin,
+	lexReadString: TokenBuiltin,
+	lexReadLine:   TokenBuiltin,
+	lexReadChar:   TokenBuiltin,
+	lexPrint:      TokenBuiltin,
+	lexPrintLn:    TokenBuiltin,
+	lexRnd:        TokenBuiltin,
+	lexOpen:       TokenBuiltin,
+	lexRead:       TokenBuiltin,
+	lexWrite:      TokenBuiltin,
+	lexClose:      TokenBuiltin,
+	lexReadLines:  TokenBuiltin,
+	lexName:       TokenName,
+	lexInt:        TokenInt,
+	lexFloat:      TokenFloat,
+	lexString:     TokenString,
+	lexBool:       TokenBool,
+	lexNil:        TokenNil,
+	lexTypeOf:     TokenKeyword,
+	lexDefined:    TokenKeyword,
+	lexToInt:      TokenKeyword,
+	lexToFloat:    TokenKeyword,
+	lexToStr:      TokenKeyword,
+	lexToBool:     TokenKeyword,
+	lexTry:        TokenKeyword,
+	lexCatch:      TokenKeyword,
+	lexFinally:    TokenKeyword,
+	lexThrow:      TokenKeyword,
+	lexFunc:       TokenKeyword,
+	lexRange:      TokenKeyword,
+	lexImport:     TokenKeyword,
+}
+
+// A Token is a lexeme of YAIL source code.
+type Token struct {
+	Kind TokenKind
+	Text string // as it appears in the source code
+	Pos  ast.Pos
+}
+
+// A Scanner splits YAIL source code to tokens on demand.
+type Scanner struct {
+	l     *lexer
+	lines lineTable
+}
+
+// Returns a scanner of source. If comments is false, comments are skipped
+// (a // comment is reported as TokenEOS because it ends the line).
+func NewScanner(source string, comments bool) *Scanner {
+	return &Scanner{newLexer(source, comments), newLineTable(source)}
+}
+
+// Returns the next token. After the end of input or an error TokenEOF is
+// returned.
+func (s *Scanner) NextToken() Token {
+	l := s.l.get()
+	kind, ok := tokenKinds[l.typ]
+	if !ok {
+		kind = TokenOperator
+	}
+	return Token{kind, l.val, s.lines.pos(l.pos)}
+}
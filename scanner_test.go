@@ -0,0 +1,64 @@
+package yail
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/mabu/yail/ast"
+)
+
+func TestScanner(t *testing.T) {
+	s := NewScanner("if x1 >= 2.5 { // c\n\t@println(\"a\", true) } $ y", true)
+	for _, expect := range []Token{
+		{TokenKeyword, "if", ast.Pos{Line: 1, Col: 1}},
+		{TokenName, "x1", ast.Pos{Line: 1, Col: 4}},
+		{TokenOperator, ">=", ast.Pos{Line: 1, Col: 7}},
+		{TokenFloat, "2.5", ast.Pos{Line: 1, Col: 10}},
+		{TokenOperator, "{", ast.Pos{Line: 1, Col: 14}},
+		{TokenComment, "// c", ast.Pos{Line: 1, Col: 16}},
+		{TokenEOS, "\n", ast.Pos{Line: 1, Col: 20}},
+		{TokenBuiltin, "@println", ast.Pos{Line: 2, Col: 2}},
+		{TokenOperator, "(", ast.Pos{Line: 2, Col: 10}},
+		{TokenString, `"a"`, ast.Pos{Line: 2, Col: 11}},
+		{TokenOperator, ",", ast.Pos{Line: 2, Col: 14}},
+		{TokenBool, "true", ast.Pos{Line: 2, Col: 16}},
+		{TokenOperator, ")", ast.Pos{Line: 2, Col: 20}},
+		{TokenOperator, "}", ast.Pos{Line: 2, Col: 22}},
+		{TokenError, "$", ast.Pos{Line: 2, Col: 24}},
+		{TokenEOF, "", ast.Pos{Line: 2, Col: 27}},
+		{TokenEOF, "", ast.Pos{Line: 2, Col: 27}},
+	} {
+		if got := s.NextToken(); got != expect {
+			t.Errorf("Got %v, expected %v.", got, expect)
+		}
+	}
+}
+
+func TestScannerKinds(t *testing.T) {
+	for text, kind := range map[string]TokenKind{
+		"import": TokenKeyword,
+		"as":     TokenName,
+		"func":   TokenKeyword,
+	} {
+		if got := NewScanner(text, false).NextToken(); got.Kind != kind || got.Text != text {
+			t.Errorf("Got %v, expected %q of kind %v.", got, text, kind)
+		}
+	}
+	for text := range builtins {
+		if got := NewScanner(text, false).NextToken(); got.Kind != TokenBuiltin {
+			t.Errorf("Got %v, expected builtin %s.", got, text)
+		}
+	}
+}
+
+func TestParseErrorNoLeak(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		if _, err := ParseFile("a = (\nb = 1\nc = 2\nd = 3\ne = 4\nf = 5\ng = 6\nh = 7\ni = 8\nj = 9\nk = 10\nl = 11"); err == nil {
+			t.Fatal("Expected parse error.")
+		}
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Errorf("Goroutines leaked: %d before parsing, %d after.", before, after)
+	}
+}
@@ -1,24 +1,212 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"github.com/mabu/yail"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const usage = `Usage:
+	yail_interpreter [flags] file.yail...          runs programs
+	yail_interpreter [flags] run file.yail[c]      runs a program or compiled bytecode
+	yail_interpreter [flags] compile file.yail...  compiles programs to .yailc files
+	yail_interpreter lint file.yail...             reports suspicious code
+	yail_interpreter [flags] bench file.yail       measures speed of a program
+Flags:`
+
+var (
+	opts       yail.Options
+	benchTime  = flag.Duration("benchtime", time.Second, "minimal duration of bench")
+	filesRoot  = flag.String("files", "", "directory whose files programs can use, none if empty")
+	readOnly   = flag.Bool("readonly", false, "do not allow programs to write files")
+	searchPath = flag.String("path", "", "directories where imported modules are looked for after the directory of the program, separated by "+string(filepath.ListSeparator))
 )
 
 func main() {
-	if len(os.Args) == 1 {
+	flag.BoolVar(&opts.NoOptimize, "noopt", false, "disable the bytecode optimizer")
+	flag.Usage = func() {
+		fmt.Println(usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 {
 		fmt.Println("Please pass a file name as an argument.")
+		flag.Usage()
 		os.Exit(1)
 	}
-	for i := 1; i < len(os.Args); i++ {
-		fmt.Println("Starting program", os.Args[i])
-		source, err := ioutil.ReadFile(os.Args[i])
+	switch args[0] {
+	case "run":
+		if len(args) != 2 {
+			flag.Usage()
+			os.Exit(1)
+		}
+		run(args[1])
+	case "compile":
+		if len(args) == 1 {
+			flag.Usage()
+			os.Exit(1)
+		}
+		for _, name := range args[1:] {
+			compile(name)
+		}
+	case "lint":
+		if len(args) == 1 {
+			flag.Usage()
+			os.Exit(1)
+		}
+		ok := true
+		for _, name := range args[1:] {
+			ok = lint(name) && ok
+		}
+		if !ok {
+			os.Exit(1)
+		}
+	case "bench":
+		if len(args) != 2 {
+			flag.Usage()
+			os.Exit(1)
+		}
+		bench(args[1])
+	default:
+		for _, name := range args {
+			fmt.Println("Starting program", name)
+			run(name)
+		}
+	}
+}
+
+func run(name string) {
+	prog := load(name)
+	var files *yail.Files
+	if *filesRoot != "" {
+		files = &yail.Files{Root: *filesRoot, ReadOnly: *readOnly}
+	}
+	if err := prog.RunWithFiles(os.Stdin, os.Stdout, files); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+// compiles a source file or reads bytecode, exits on errors
+func load(name string) *yail.Program {
+	if filepath.Ext(name) == ".yailc" {
+		f, err := os.Open(name)
+		if err != nil {
+			fmt.Println("Could not open file:", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		prog, err := yail.Decode(f)
 		if err != nil {
-			fmt.Println("Could not read file:", err)
+			fmt.Println("Could not load bytecode:", err)
 			os.Exit(1)
 		}
-		yail.Interpret(string(source), os.Stdin, os.Stdout)
+		return prog
+	}
+	prog, err := yail.Compile(readSource(name), options(name))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	return prog
+}
+
+// returns options for compiling name: modules are looked for in its directory
+// first
+func options(name string) *yail.Options {
+	o := opts
+	o.Loader = osLoader(append([]string{filepath.Dir(name)}, filepath.SplitList(*searchPath)...))
+	return &o
+}
+
+// reads modules from the directories of a search path
+type osLoader []string
+
+func (dirs osLoader) Load(path string) (string, []byte, error) {
+	if filepath.IsAbs(path) {
+		dirs = osLoader{""}
+	}
+	for _, dir := range dirs {
+		name := filepath.Join(dir, filepath.FromSlash(path))
+		source, err := ioutil.ReadFile(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		return name, source, err
+	}
+	return "", nil, os.ErrNotExist
+}
+
+// compiles name to a file with .yailc extension
+func compile(name string) {
+	prog := load(name)
+	out := strings.TrimSuffix(name, filepath.Ext(name)) + ".yailc"
+	f, err := os.Create(out)
+	if err != nil {
+		fmt.Println("Could not create file:", err)
+		os.Exit(1)
+	}
+	if err = prog.Encode(f); err == nil {
+		err = f.Close()
+	}
+	if err != nil {
+		fmt.Println("Could not write bytecode:", err)
+		os.Exit(1)
+	}
+}
+
+// runs a program repeatedly with empty input and discarded output, prints
+// compilation time and speed of the interpreter
+func bench(name string) {
+	source := readSource(name)
+	start := time.Now()
+	prog, err := yail.Compile(source, options(name))
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	fmt.Printf("compile: %v\n", time.Since(start))
+	var runs, ops uint64
+	start = time.Now()
+	for runs == 0 || time.Since(start) < *benchTime {
+		n, err := prog.RunCount(strings.NewReader(""), ioutil.Discard)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		ops += n
+		runs++
+	}
+	elapsed := time.Since(start)
+	fmt.Printf("run: %d times, %v per run\n", runs, elapsed/time.Duration(runs))
+	fmt.Printf("ops: %d per run, %.0f ops/sec\n", ops/runs, float64(ops)/elapsed.Seconds())
+}
+
+// prints problems found in name, returns false if there were any
+func lint(name string) bool {
+	file, err := yail.ParseFile(readSource(name))
+	if err != nil {
+		fmt.Printf("%s: %v\n", name, err)
+		return false
+	}
+	problems := yail.Lint(file)
+	for _, p := range problems {
+		fmt.Printf("%s:%v\n", name, p)
+	}
+	return len(problems) == 0
+}
+
+func readSource(name string) string {
+	source, err := ioutil.ReadFile(name)
+	if err != nil {
+		fmt.Println("Could not read file:", err)
+		os.Exit(1)
 	}
+	return string(source)
 }
@@ -0,0 +1,78 @@
+// yailfmt formats YAIL programs.
+//
+// Without file arguments it reads standard input and writes the formatted
+// program to standard output.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/mabu/yail"
+	"io/ioutil"
+	"os"
+)
+
+var (
+	write = flag.Bool("w", false, "write result to the source file instead of standard output")
+	list  = flag.Bool("l", false, "list files whose formatting differs from yailfmt's")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: yailfmt [flags] [file.yail...]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		if *write {
+			fmt.Fprintln(os.Stderr, "Cannot use -w with standard input.")
+			os.Exit(2)
+		}
+		source, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Could not read standard input:", err)
+			os.Exit(1)
+		}
+		if !format("<standard input>", string(source)) {
+			os.Exit(1)
+		}
+		return
+	}
+	ok := true
+	for _, name := range flag.Args() {
+		source, err := ioutil.ReadFile(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Could not read file:", err)
+			ok = false
+			continue
+		}
+		ok = format(name, string(source)) && ok
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+func format(name, source string) bool {
+	res, err := yail.Format(source)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+		return false
+	}
+	if *list {
+		if res != source {
+			fmt.Println(name)
+		}
+	}
+	if *write {
+		if res != source {
+			if err := ioutil.WriteFile(name, []byte(res), 0644); err != nil {
+				fmt.Fprintln(os.Stderr, "Could not write file:", err)
+				return false
+			}
+		}
+	} else if !*list {
+		fmt.Print(res)
+	}
+	return true
+}